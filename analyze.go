@@ -0,0 +1,330 @@
+package env_strings
+
+import (
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+type FieldReference struct {
+	Path     string `json:"path"`
+	Position string `json:"position"`
+
+	node parse.Node
+	keys []string
+	// base is how many of the keys are of the dot or the variable
+	base int
+}
+
+type FuncReference struct {
+	Name     string   `json:"name"`
+	Args     []string `json:"args"`
+	Position string   `json:"position"`
+
+	node *parse.CommandNode
+}
+
+// DynamicReference is a field or a func call of the template which could not
+// be resolved statically, such as a field under range or a call with an arg
+// from a field
+type DynamicReference struct {
+	Node     string `json:"node"`
+	Position string `json:"position"`
+	Reason   string `json:"reason"`
+}
+
+// Analysis lists what a template depends on
+type Analysis struct {
+	Fields  []FieldReference   `json:"fields"`
+	Calls   []FuncReference    `json:"calls"`
+	Dynamic []DynamicReference `json:"dynamic"`
+}
+
+// FieldPaths returns the distinct paths of the fields, sorted
+func (p *Analysis) FieldPaths() []string {
+	return distinctSorted(len(p.Fields), func(i int) string { return p.Fields[i].Path })
+}
+
+// FuncNames returns the distinct names of the called funcs, sorted
+func (p *Analysis) FuncNames() []string {
+	return distinctSorted(len(p.Calls), func(i int) string { return p.Calls[i].Name })
+}
+
+func distinctSorted(n int, fn func(i int) string) (ret []string) {
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		str := fn(i)
+		if seen[str] {
+			continue
+		}
+		seen[str] = true
+		ret = append(ret, str)
+	}
+	sort.Strings(ret)
+	return
+}
+
+// Analyze parses the template with the funcs of the env strings and returns
+// the field paths, the func calls with literal args and the references which
+// depend on the values at render time, nothing is read or called
+func (p *EnvStrings) Analyze(str string) (analysis *Analysis, err error) {
+	return p.analyze("tmpl:"+p.envName, str)
+}
+
+func (p *EnvStrings) analyze(name, str string) (analysis *Analysis, err error) {
+	var tpl *template.Template
+	if tpl, err = template.New(name).Funcs(p.tmplFuncs.GetFuncMaps(p.envName)).Option("missingkey=error").Parse(str); err != nil {
+		return
+	}
+
+	analysis = analyzeTemplate(tpl)
+
+	return
+}
+
+func analyzeTemplate(tpl *template.Template) *Analysis {
+	a := &analyzer{tpl: tpl, analysis: &Analysis{}}
+
+	for _, t := range tpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+
+		// the dot of the named templates depends on the caller, so it is unknown
+		var dot []string
+		if t.Name() == tpl.Name() {
+			dot = []string{}
+		}
+
+		a.list(t.Tree.Root, dot, map[string][]string{"$": dot})
+	}
+
+	return a.analysis
+}
+
+// templateBuiltins are the funcs predefined by text/template, which do not
+// depend on anything but their args
+var templateBuiltins = map[string]bool{
+	"and": true, "or": true, "not": true, "len": true, "index": true, "slice": true,
+	"print": true, "printf": true, "println": true, "html": true, "js": true, "urlquery": true,
+	"call": true, "eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+}
+
+type analyzer struct {
+	tpl      *template.Template
+	analysis *Analysis
+}
+
+func (p *analyzer) position(node parse.Node) string {
+	location, _ := p.tpl.ErrorContext(node)
+	return location
+}
+
+func (p *analyzer) dynamic(node parse.Node, reason string) {
+	p.analysis.Dynamic = append(p.analysis.Dynamic, DynamicReference{
+		Node:     node.String(),
+		Position: p.position(node),
+		Reason:   reason,
+	})
+}
+
+// list analyzes the nodes with the keys of dot and of the variables, a nil
+// dot or variable is unknown
+func (p *analyzer) list(list *parse.ListNode, dot []string, vars map[string][]string) {
+	if list == nil {
+		return
+	}
+
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			{
+				p.pipe(n.Pipe, dot, vars)
+			}
+		case *parse.IfNode:
+			{
+				// the variables of the pipeline are visible in the else branch too
+				scope := copyVars(vars)
+				p.pipe(n.Pipe, dot, scope)
+				p.list(n.List, dot, copyVars(scope))
+				p.list(n.ElseList, dot, copyVars(scope))
+			}
+		case *parse.WithNode:
+			{
+				scope := copyVars(vars)
+				keys := p.pipe(n.Pipe, dot, scope)
+				p.list(n.List, keys, copyVars(scope))
+				p.list(n.ElseList, dot, copyVars(scope))
+			}
+		case *parse.RangeNode:
+			{
+				scope := copyVars(vars)
+				p.pipe(n.Pipe, dot, scope)
+				for _, decl := range n.Pipe.Decl {
+					scope[decl.Ident[0]] = nil
+				}
+				p.list(n.List, nil, copyVars(scope))
+				p.list(n.ElseList, dot, copyVars(scope))
+			}
+		case *parse.TemplateNode:
+			{
+				p.pipe(n.Pipe, dot, vars)
+			}
+		}
+	}
+}
+
+// pipe analyzes the commands of the pipeline, and returns the keys of its
+// value if it is a single field which could be resolved
+func (p *analyzer) pipe(pipe *parse.PipeNode, dot []string, vars map[string][]string) (keys []string) {
+	if pipe == nil {
+		return
+	}
+
+	for _, cmd := range pipe.Cmds {
+		p.command(cmd, dot, vars)
+	}
+
+	if len(pipe.Cmds) == 1 && len(pipe.Cmds[0].Args) == 1 {
+		keys, _ = p.keys(pipe.Cmds[0].Args[0], dot, vars)
+	}
+
+	for _, decl := range pipe.Decl {
+		vars[decl.Ident[0]] = keys
+	}
+
+	return
+}
+
+func (p *analyzer) command(cmd *parse.CommandNode, dot []string, vars map[string][]string) {
+	if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok && !templateBuiltins[ident.Ident] {
+		call := FuncReference{Name: ident.Ident, Args: []string{}, Position: p.position(cmd), node: cmd}
+		literal, resolved := true, true
+		for _, arg := range cmd.Args[1:] {
+			if str, ok := arg.(*parse.StringNode); ok {
+				call.Args = append(call.Args, str.Text)
+				continue
+			}
+			literal = false
+			if !p.resolved(arg, dot, vars) {
+				resolved = false
+				break
+			}
+		}
+
+		if literal {
+			p.analysis.Calls = append(p.analysis.Calls, call)
+		} else if !resolved {
+			p.dynamic(cmd, "args of "+ident.Ident+" are not resolved")
+		}
+	}
+
+	for _, arg := range cmd.Args {
+		p.arg(arg, dot, vars)
+	}
+}
+
+// resolved reports whether the arg is a literal or a field whose keys are
+// known, the results of pipelines are only known at render time
+func (p *analyzer) resolved(node parse.Node, dot []string, vars map[string][]string) bool {
+	switch node.(type) {
+	case *parse.StringNode, *parse.NumberNode, *parse.BoolNode, *parse.NilNode:
+		{
+			return true
+		}
+	case *parse.FieldNode, *parse.VariableNode, *parse.DotNode:
+		{
+			keys, _ := p.keys(node, dot, vars)
+			return keys != nil
+		}
+	}
+
+	return false
+}
+
+func (p *analyzer) arg(node parse.Node, dot []string, vars map[string][]string) {
+	switch n := node.(type) {
+	case *parse.FieldNode, *parse.VariableNode:
+		{
+			p.field(n, dot, vars)
+		}
+	case *parse.ChainNode:
+		{
+			if pipe, ok := n.Node.(*parse.PipeNode); ok {
+				p.pipe(pipe, dot, copyVars(vars))
+			}
+			p.dynamic(n, "field of the result of a pipeline")
+		}
+	case *parse.PipeNode:
+		{
+			p.pipe(n, dot, copyVars(vars))
+		}
+	}
+}
+
+func (p *analyzer) field(node parse.Node, dot []string, vars map[string][]string) {
+	if keys, base := p.keys(node, dot, vars); keys != nil {
+		if len(keys) > 0 {
+			p.analysis.Fields = append(p.analysis.Fields, FieldReference{
+				Path:     "." + joinKeyPath(keys),
+				Position: p.position(node),
+				node:     node,
+				keys:     keys,
+				base:     base,
+			})
+		}
+		return
+	}
+
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		{
+			p.dynamic(node, "dot is unknown")
+		}
+	case *parse.VariableNode:
+		{
+			if len(n.Ident) > 1 {
+				p.dynamic(node, "variable "+n.Ident[0]+" is unknown")
+			}
+		}
+	}
+}
+
+// keys returns the keys of the field or the variable, nil if it is unknown,
+// and how many of them are of the dot or the variable
+func (p *analyzer) keys(node parse.Node, dot []string, vars map[string][]string) (keys []string, base int) {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		{
+			if dot == nil {
+				return
+			}
+			keys = append(append([]string{}, dot...), n.Ident...)
+			base = len(dot)
+		}
+	case *parse.VariableNode:
+		{
+			varKeys := vars[n.Ident[0]]
+			if varKeys == nil {
+				return
+			}
+			keys = append(append([]string{}, varKeys...), n.Ident[1:]...)
+			base = len(varKeys)
+		}
+	case *parse.DotNode:
+		{
+			keys = dot
+			base = len(dot)
+		}
+	}
+
+	return
+}
+
+func copyVars(vars map[string][]string) map[string][]string {
+	scope := make(map[string][]string, len(vars))
+	for name, keys := range vars {
+		scope[name] = keys
+	}
+	return scope
+}
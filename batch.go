@@ -0,0 +1,82 @@
+package env_strings
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"text/template"
+)
+
+// RenderBatch renders the templates by their names from one snapshot: the env
+// tree is loaded once, the storage values of all the templates are prefetched
+// together, and every storage lookup is memoized across the templates, so the
+// results are of the same values. The results are returned only if all the
+// templates succeeded, and the prefetch too unless the lookups could fall back
+// to the last known good snapshot
+func (p *EnvStrings) RenderBatch(templates map[string]string) (results map[string]string, err error) {
+	return p.RenderBatchContext(context.Background(), templates)
+}
+
+func (p *EnvStrings) RenderBatchContext(ctx context.Context, templates map[string]string) (results map[string]string, err error) {
+	// the templates share one render scope, so they read one generation
+	ctx = withRenderScope(ctx)
+
+	var tree *envTree
+	if tree, err = p.loadTree(nil); err != nil {
+		err = p.tmplFuncs.Redactor().Error(err)
+		return
+	}
+
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tpls := make([]*template.Template, len(names))
+	for i, name := range names {
+		if tpls[i], err = template.New(name).Funcs(p.tmplFuncs.GetFuncMaps(p.envName)).Option("missingkey=error").Parse(templates[name]); err != nil {
+			return
+		}
+	}
+
+	var prefetched template.FuncMap
+	if !p.replaying() {
+		if prefetched, err = p.prefetch(ctx, tpls...); err != nil {
+			if p.snapshotCache == nil {
+				err = fmt.Errorf("prefetch of the batch failure, the templates could not be rendered from one snapshot: %w", p.tmplFuncs.Redactor().Error(err))
+				return
+			}
+			// the lookups fall back to the snapshot one by one
+			err = nil
+		}
+
+		if prefetched == nil {
+			prefetched = template.FuncMap{}
+		}
+	}
+
+	memo := newFuncCache(0)
+	rendered := make(map[string]string, len(templates))
+
+	for i, name := range names {
+		r := newRender(ctx, p.envName)
+		r.name = name
+		r.tpl = tpls[i]
+		r.tree = tree
+		r.prefetched = prefetched
+		r.memo = memo
+
+		var buf bytes.Buffer
+		if err = p.execute(r, templates[name], nil, &buf); err != nil {
+			return
+		}
+
+		rendered[name] = buf.String()
+	}
+
+	results = rendered
+
+	return
+}
@@ -0,0 +1,165 @@
+package env_strings
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+)
+
+// KeyCoverage is a leaf key of the env tree or a key of a storage, with the
+// templates referencing it, the key is unused if there is none
+type KeyCoverage struct {
+	Path      string   `json:"path"`
+	Templates []string `json:"templates,omitempty"`
+}
+
+type StorageKeyCoverage struct {
+	StorageKey
+	Templates []string `json:"templates,omitempty"`
+}
+
+// CoverageReport lists the keys of the env tree and of the storages which
+// could list their keys, with the templates referencing them. The storage keys
+// referenced but not listed are reported too, such as the keys of the storages
+// which failed to list them, whose errors are reported. The dynamic references
+// could not be resolved statically, the keys they use may be reported as unused
+type CoverageReport struct {
+	Templates     []string             `json:"templates"`
+	Keys          []KeyCoverage        `json:"keys"`
+	StorageKeys   []StorageKeyCoverage `json:"storage_keys"`
+	StorageErrors []string             `json:"storage_errors,omitempty"`
+	Dynamic       []DynamicReference   `json:"dynamic,omitempty"`
+}
+
+func (p *CoverageReport) UnusedKeys() (paths []string) {
+	for _, key := range p.Keys {
+		if len(key.Templates) == 0 {
+			paths = append(paths, key.Path)
+		}
+	}
+	return
+}
+
+func (p *CoverageReport) UnusedStorageKeys() (keys []StorageKey) {
+	for _, key := range p.StorageKeys {
+		if len(key.Templates) == 0 {
+			keys = append(keys, key.StorageKey)
+		}
+	}
+	return
+}
+
+// Coverage analyzes the template files and reports which keys of the env tree
+// and of the storages they reference
+func (p *EnvStrings) Coverage(files ...string) (report *CoverageReport, err error) {
+	return p.CoverageContext(context.Background(), files...)
+}
+
+func (p *EnvStrings) CoverageContext(ctx context.Context, files ...string) (report *CoverageReport, err error) {
+	var tree *envTree
+	if tree, err = p.loadTree(nil); err != nil {
+		return
+	}
+
+	keyTemplates := make(map[string][]string)
+	for leaf := range tree.sources {
+		keyTemplates[leaf] = nil
+	}
+
+	report = &CoverageReport{Templates: files}
+
+	storageTemplates := make(map[StorageKey][]string)
+	for _, extFuncs := range p.extFuncs {
+		lister, ok := extFuncs.(ExtFuncsKeyLister)
+		if !ok {
+			continue
+		}
+
+		keys, e := lister.ListKeys(ctx)
+		if e != nil {
+			// the env keys need no storage, so they are still reported
+			e = p.tmplFuncs.Redactor().Error(e)
+			p.logger.Warn("list storage keys failure", "env", p.envName, "error", e)
+			report.StorageErrors = append(report.StorageErrors, e.Error())
+			continue
+		}
+
+		for _, key := range keys {
+			storageTemplates[key] = nil
+		}
+	}
+
+	for _, file := range files {
+		var data []byte
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return
+		}
+
+		var analysis *Analysis
+		if analysis, err = p.analyze(file, string(data)); err != nil {
+			return
+		}
+
+		for _, path := range analysis.FieldPaths() {
+			for _, leaf := range tree.referencedLeaves(splitKeyPath(path[1:])) {
+				keyTemplates[leaf] = appendTemplate(keyTemplates[leaf], file)
+			}
+		}
+
+		for _, call := range analysis.Calls {
+			for _, extFuncs := range p.extFuncs {
+				lister, ok := extFuncs.(ExtFuncsKeyLister)
+				if !ok {
+					continue
+				}
+
+				if key, ok := lister.CallKey(FuncCall{Name: call.Name, Args: call.Args}); ok {
+					storageTemplates[key] = appendTemplate(storageTemplates[key], file)
+				}
+			}
+		}
+
+		report.Dynamic = append(report.Dynamic, analysis.Dynamic...)
+	}
+
+	for path, templates := range keyTemplates {
+		report.Keys = append(report.Keys, KeyCoverage{Path: path, Templates: templates})
+	}
+
+	sort.Slice(report.Keys, func(i, j int) bool {
+		return report.Keys[i].Path < report.Keys[j].Path
+	})
+
+	for key, templates := range storageTemplates {
+		report.StorageKeys = append(report.StorageKeys, StorageKeyCoverage{StorageKey: key, Templates: templates})
+	}
+
+	sort.Slice(report.StorageKeys, func(i, j int) bool {
+		return report.StorageKeys[i].String() < report.StorageKeys[j].String()
+	})
+
+	return
+}
+
+// referencedLeaves returns the leaves used by a reference of the keys, the
+// leaves under them, or the leaf above them
+func (p *envTree) referencedLeaves(keys []string) (leaves []string) {
+	for i := len(keys); i > 0; i-- {
+		if _, exist := p.sources[joinKeyPath(keys[:i])]; exist {
+			return []string{joinKeyPath(keys[:i])}
+		}
+	}
+
+	for _, provenance := range p.explain(joinKeyPath(keys)) {
+		leaves = append(leaves, provenance.Path)
+	}
+
+	return
+}
+
+func appendTemplate(templates []string, file string) []string {
+	if n := len(templates); n > 0 && templates[n-1] == file {
+		return templates
+	}
+	return append(templates, file)
+}
@@ -0,0 +1,309 @@
+package env_strings
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	DAEMON_DEFAULT_INTERVAL     = 10 * time.Second
+	DAEMON_DEFAULT_MIN_INTERVAL = time.Second
+)
+
+// DaemonStatus is the health of the daemon, it is healthy while the last
+// render succeeded
+type DaemonStatus struct {
+	Healthy     bool      `json:"healthy"`
+	Watching    bool      `json:"watching"`
+	Renders     int       `json:"renders"`
+	Failures    int       `json:"failures"`
+	LastRender  time.Time `json:"last_render"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Daemon re-renders a manifest when the env files, the templates or the
+// storage values of the literal calls change. They are polled every interval,
+// and the storages implementing ExtFuncsWatcher trigger a render on their
+// notifications, the renders are at least min interval apart
+type Daemon struct {
+	envStrings  *EnvStrings
+	manifest    *RenderManifest
+	interval    time.Duration
+	minInterval time.Duration
+
+	locker      sync.Mutex
+	status      DaemonStatus
+	fingerprint []byte
+}
+
+func (p *EnvStrings) NewDaemon(manifest *RenderManifest, interval, minInterval time.Duration) *Daemon {
+	if interval <= 0 {
+		interval = DAEMON_DEFAULT_INTERVAL
+	}
+
+	if minInterval <= 0 {
+		minInterval = DAEMON_DEFAULT_MIN_INTERVAL
+	}
+
+	return &Daemon{
+		envStrings:  p,
+		manifest:    manifest,
+		interval:    interval,
+		minInterval: minInterval,
+	}
+}
+
+func (p *Daemon) Status() DaemonStatus {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.status
+}
+
+// ServeHTTP writes the status as json, with 503 if it is not healthy
+func (p *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := p.Status()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
+
+// Run renders the manifest and re-renders it on changes until the ctx is done
+func (p *Daemon) Run(ctx context.Context) (err error) {
+	logger := p.envStrings.logger
+
+	triggers := make(chan struct{}, 1)
+
+	if changes, e := p.envStrings.Watch(ctx); e != nil {
+		logger.Info("watch storages failure, polling only", "env", p.envStrings.envName, "error", e)
+	} else {
+		p.setWatching(true)
+
+		go func() {
+			for key := range changes {
+				logger.Debug("storage key changed", "env", p.envStrings.envName, "key", key.String())
+
+				select {
+				case triggers <- struct{}{}:
+				default:
+				}
+			}
+
+			p.setWatching(false)
+
+			if ctx.Err() == nil {
+				logger.Warn("watch storages stopped, polling only", "env", p.envStrings.envName)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var lastRender time.Time
+	var delayed <-chan time.Time
+
+	// the fingerprint of the poll is passed through, or taken by the render
+	request := func(fingerprint []byte) {
+		if wait := p.minInterval - time.Since(lastRender); wait > 0 {
+			if delayed == nil {
+				delayed = time.After(wait)
+			}
+			return
+		}
+
+		p.render(ctx, fingerprint)
+		lastRender = time.Now()
+	}
+
+	request(nil)
+
+	for {
+		select {
+		case <-ctx.Done():
+			{
+				return
+			}
+		case <-ticker.C:
+			{
+				if fingerprint, changed := p.changed(ctx); changed {
+					request(fingerprint)
+				}
+			}
+		case <-triggers:
+			{
+				request(nil)
+			}
+		case <-delayed:
+			{
+				delayed = nil
+				request(nil)
+			}
+		}
+	}
+}
+
+func (p *Daemon) setWatching(watching bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.status.Watching = watching
+}
+
+// changed returns the fingerprint, and reports whether it differs from the
+// last render
+func (p *Daemon) changed(ctx context.Context) (fingerprint []byte, changed bool) {
+	fingerprint = p.fingerprintOf(ctx)
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	changed = string(fingerprint) != string(p.fingerprint)
+
+	return
+}
+
+// render renders the manifest, the fingerprint is taken before the render if
+// it is nil
+func (p *Daemon) render(ctx context.Context, fingerprint []byte) {
+	if fingerprint == nil {
+		fingerprint = p.fingerprintOf(ctx)
+	}
+
+	results, err := p.envStrings.RenderManifestContext(ctx, p.manifest)
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.status.Renders++
+	p.status.LastRender = time.Now()
+
+	if err != nil {
+		p.status.Healthy = false
+		p.status.Failures++
+		p.status.LastError = err.Error()
+		// retry on the next poll
+		p.fingerprint = nil
+
+		p.envStrings.logger.Warn("render manifest failure", "env", p.envStrings.envName, "error", err)
+		return
+	}
+
+	p.status.Healthy = true
+	p.status.LastSuccess = p.status.LastRender
+	p.status.LastError = ""
+	p.fingerprint = fingerprint
+
+	changed := 0
+	for _, result := range results {
+		if result.Changed {
+			changed++
+		}
+	}
+
+	p.envStrings.logger.Info("manifest rendered", "env", p.envStrings.envName, "templates", len(results), "changed", changed)
+}
+
+// fingerprintOf hashes the stats of the env files and the templates, and the
+// storage values of the literal calls of the templates
+func (p *Daemon) fingerprintOf(ctx context.Context) []byte {
+	h := sha256.New()
+
+	for _, path := range strings.Split(os.Getenv(p.envStrings.envName), ";") {
+		if path != "" {
+			hashFileStats(h, path)
+		}
+	}
+
+	var tpls []*template.Template
+
+	for _, conf := range p.manifest.Templates {
+		hashFileStats(h, conf.Src)
+
+		data, err := ioutil.ReadFile(conf.Src)
+		if err != nil {
+			continue
+		}
+
+		tpl, err := template.New(conf.Src).Funcs(p.envStrings.tmplFuncs.GetFuncMaps(p.envStrings.envName)).Parse(string(data))
+		if err != nil {
+			continue
+		}
+
+		tpls = append(tpls, tpl)
+	}
+
+	for _, extFuncs := range p.envStrings.extFuncs {
+		prefetcher, ok := extFuncs.(ExtFuncsPrefetcher)
+		if !ok {
+			continue
+		}
+
+		var calls []FuncCall
+		for _, tpl := range tpls {
+			calls = append(calls, literalCalls(tpl, prefetcher.GetFuncs())...)
+		}
+
+		if len(calls) == 0 {
+			continue
+		}
+
+		funcs, err := p.envStrings.prefetchStorage(ctx, prefetcher, calls)
+		if err != nil {
+			continue
+		}
+
+		for _, call := range calls {
+			fn, exist := funcs[call.Name]
+			if !exist {
+				continue
+			}
+
+			args := make([]interface{}, len(call.Args))
+			for i, arg := range call.Args {
+				args[i] = arg
+			}
+
+			ret, err := callContext(ctx, fn, args...)
+			fmt.Fprintf(h, "%s %q=%v,%v\n", call.Name, call.Args, ret, err)
+		}
+	}
+
+	return h.Sum(nil)
+}
+
+func hashFileStats(h hash.Hash, root string) {
+	var lines []string
+
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			lines = append(lines, path+" "+err.Error())
+			return nil
+		}
+		lines = append(lines, fmt.Sprintf("%s %d %d %s", path, info.Size(), info.ModTime().UnixNano(), info.Mode()))
+		return nil
+	})
+
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		fmt.Fprintln(h, line)
+	}
+}
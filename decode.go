@@ -0,0 +1,150 @@
+package env_strings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DECODE_FORMAT_JSON = "json"
+	DECODE_FORMAT_YAML = "yaml"
+)
+
+var yamlLineRegexp = regexp.MustCompile(`line (\d+):`)
+
+// ExecuteInto renders the template and decodes it into v, as json or as yaml
+// by the option of EnvStringsDecodeFormat. The decode errors are returned as
+// *DecodeError with the position of the action which rendered the offending
+// value
+func (p *EnvStrings) ExecuteInto(str string, v interface{}) (err error) {
+	return p.ExecuteContextInto(context.Background(), str, nil, v)
+}
+
+func (p *EnvStrings) ExecuteFileInto(fileName string, v interface{}) (err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(fileName); err != nil {
+		return
+	}
+
+	r := newRender(context.Background(), p.envName)
+	r.name = fileName
+
+	return p.executeInto(r, string(data), nil, v)
+}
+
+func (p *EnvStrings) ExecuteContextInto(ctx context.Context, str string, envValues map[string]interface{}, v interface{}) (err error) {
+	return p.executeInto(newRender(ctx, p.envName), str, envValues, v)
+}
+
+func (p *EnvStrings) executeInto(r *render, str string, envValues map[string]interface{}, v interface{}) (err error) {
+	r.tracer = newRenderTracer()
+
+	var buf bytes.Buffer
+	if err = p.execute(r, str, envValues, &buf); err != nil {
+		return
+	}
+
+	data := buf.Bytes()
+
+	switch p.decodeFormat {
+	case DECODE_FORMAT_YAML:
+		{
+			if err = yaml.Unmarshal(data, v); err != nil {
+				start, end := yamlErrorLine(err, data)
+				err = p.tmplFuncs.Redactor().Error(decodeError(err, r.tracer.spans, start, end))
+			}
+		}
+	default:
+		{
+			if err = json.Unmarshal(data, v); err != nil {
+				start, end := jsonErrorRange(err, data)
+				err = p.tmplFuncs.Redactor().Error(decodeError(err, r.tracer.spans, start, end))
+			}
+		}
+	}
+
+	return
+}
+
+// decodeError finds the first span of the output within start and end, a
+// negative start is unknown
+func decodeError(err error, spans []RenderSpan, start, end int) error {
+	decodeErr := &DecodeError{Err: err}
+
+	if start < 0 {
+		return decodeErr
+	}
+
+	for _, span := range spans {
+		if span.Start < end && span.End > start {
+			decodeErr.Position = span.Position
+			decodeErr.Node = span.Node
+			break
+		}
+	}
+
+	return decodeErr
+}
+
+// jsonErrorRange returns the offsets of the offending value, the offset of the
+// errors is the end of the value read
+func jsonErrorRange(err error, data []byte) (start, end int) {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return int(syntaxErr.Offset) - 1, int(syntaxErr.Offset)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Offset <= 0 || int(typeErr.Offset) > len(data) {
+		return -1, -1
+	}
+
+	end = int(typeErr.Offset)
+	start = end - 1
+
+	if data[start] == '"' {
+		for start--; start > 0 && (data[start] != '"' || data[start-1] == '\\'); start-- {
+		}
+		return
+	}
+
+	for start > 0 && !strings.ContainsRune(",:[{ \t\r\n", rune(data[start-1])) {
+		start--
+	}
+
+	return
+}
+
+// yamlErrorLine returns the offsets of the line of the first error
+func yamlErrorLine(err error, data []byte) (start, end int) {
+	matches := yamlLineRegexp.FindStringSubmatch(err.Error())
+	if matches == nil {
+		return -1, -1
+	}
+
+	line, _ := strconv.Atoi(matches[1])
+
+	for i := 1; i < line; i++ {
+		next := bytes.IndexByte(data[start:], '\n')
+		if next < 0 {
+			return -1, -1
+		}
+		start += next + 1
+	}
+
+	if end = bytes.IndexByte(data[start:], '\n'); end < 0 {
+		end = len(data)
+	} else {
+		end += start
+	}
+
+	return
+}
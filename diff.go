@@ -0,0 +1,211 @@
+package env_strings
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContextLines = 3
+	// bounds the time of a diff, its space is linear
+	diffMaxCells = 1 << 24
+)
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the diff of the lines of a and b in the unified format
+// with 3 lines of context, an empty string if they are equal
+func unifiedDiff(nameA, nameB, a, b string) string {
+	if a == b {
+		return ""
+	}
+
+	linesA, linesB := splitLines(a), splitLines(b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+
+	if len(linesA)*len(linesB) > diffMaxCells {
+		fmt.Fprintf(&out, "@@ -1,%d +1,%d @@ diff too large\n", len(linesA), len(linesB))
+		return out.String()
+	}
+
+	lines := diffLines(linesA, linesB)
+
+	for start := 0; start < len(lines); {
+		// find the next change
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		from := start - diffContextLines
+		if from < 0 {
+			from = 0
+		}
+
+		// extend the hunk while the changes are close enough
+		end, equal := start, 0
+		for end < len(lines) && equal <= 2*diffContextLines {
+			if lines[end].op == ' ' {
+				equal++
+			} else {
+				equal = 0
+			}
+			end++
+		}
+		if equal > diffContextLines {
+			end -= equal - diffContextLines
+		}
+
+		lineA, lineB := 1, 1
+		for _, line := range lines[:from] {
+			if line.op != '+' {
+				lineA++
+			}
+			if line.op != '-' {
+				lineB++
+			}
+		}
+
+		countA, countB := 0, 0
+		for _, line := range lines[from:end] {
+			if line.op != '+' {
+				countA++
+			}
+			if line.op != '-' {
+				countB++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+		for _, line := range lines[from:end] {
+			fmt.Fprintf(&out, "%c%s\n", line.op, line.text)
+		}
+
+		start = end
+	}
+
+	return out.String()
+}
+
+func splitLines(str string) []string {
+	if str == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(str, "\n"), "\n")
+}
+
+// diffLines returns the lines of a and b by the longest common subsequence,
+// which is found by the algorithm of Hirschberg in linear space
+func diffLines(a, b []string) []diffLine {
+	return appendDiff(nil, a, b)
+}
+
+func appendDiff(lines []diffLine, a, b []string) []diffLine {
+	// the common prefix and suffix are equal lines
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		lines = append(lines, diffLine{' ', a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		{
+			for _, line := range b {
+				lines = append(lines, diffLine{'+', line})
+			}
+		}
+	case len(b) == 0:
+		{
+			for _, line := range a {
+				lines = append(lines, diffLine{'-', line})
+			}
+		}
+	case len(a) == 1:
+		{
+			j := 0
+			for j < len(b) && b[j] != a[0] {
+				j++
+			}
+
+			if j == len(b) {
+				lines = append(lines, diffLine{'-', a[0]})
+			}
+
+			for k, line := range b {
+				if k == j {
+					lines = append(lines, diffLine{' ', line})
+				} else {
+					lines = append(lines, diffLine{'+', line})
+				}
+			}
+		}
+	default:
+		{
+			// split b where the lcs of the halves of a is the longest
+			mid := len(a) / 2
+			forward := lcsLengths(a[:mid], b, false)
+			backward := lcsLengths(a[mid:], b, true)
+
+			split := 0
+			for j := range forward {
+				if forward[j]+backward[len(b)-j] > forward[split]+backward[len(b)-split] {
+					split = j
+				}
+			}
+
+			lines = appendDiff(lines, a[:mid], b[:split])
+			lines = appendDiff(lines, a[mid:], b[split:])
+		}
+	}
+
+	for _, line := range common {
+		lines = append(lines, diffLine{' ', line})
+	}
+
+	return lines
+}
+
+// lcsLengths returns the lengths of the lcs of a and every prefix of b, or of
+// every suffix of b by its length if reverse is set
+func lcsLengths(a, b []string, reverse bool) []int {
+	at := func(lines []string, i int) string {
+		if reverse {
+			return lines[len(lines)-1-i]
+		}
+		return lines[i]
+	}
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for i := range a {
+		for j := range b {
+			if at(a, i) == at(b, j) {
+				cur[j+1] = prev[j] + 1
+			} else if prev[j+1] >= cur[j] {
+				cur[j+1] = prev[j+1]
+			} else {
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev
+}
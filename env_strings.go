@@ -2,13 +2,19 @@ package env_strings
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 )
 
 const (
@@ -26,10 +32,17 @@ const (
 
 type EnvStringConfig struct {
 	Storages []StorageConfig `json:"storages"`
+	Snapshot *SnapshotConfig `json:"snapshot,omitempty"`
+	Lockfile *LockfileConfig `json:"lockfile,omitempty"`
+	HTTP     *HTTPConfig     `json:"http,omitempty"`
+	Redact   *RedactConfig   `json:"redact,omitempty"`
+	Cache    *CacheConfig    `json:"cache,omitempty"`
+	Policies *PoliciesConfig `json:"policies,omitempty"`
 }
 
 type StorageConfig struct {
 	Engine  string                 `json:"engine"`
+	Secret  bool                   `json:"secret"`
 	Options map[string]interface{} `json:"options"`
 }
 
@@ -39,10 +52,24 @@ type EnvStrings struct {
 	envName   string
 	envExt    string
 	tmplFuncs *TemplateFuncs
+	extFuncs  []ExtFuncs
 
 	configFile string
 
 	envConfig EnvStringConfig
+
+	snapshotCache *SnapshotCache
+	lockfile      *Lockfile
+	logger        *slog.Logger
+	decodeFormat  string
+
+	httpConfigured   bool
+	redactConfigured bool
+	cacheConfigured  bool
+	policyConfigured bool
+
+	storageCacheTTLs map[string]time.Duration
+	storagePolicies  map[string]*CallPolicy
 }
 
 func FuncMap(name string, function interface{}) option {
@@ -57,16 +84,132 @@ func EnvStringsConfig(fileName string) option {
 	}
 }
 
+// EnvStringsSnapshot enables falling back to the last known good values stored at
+// path while the storage backends are unavailable
+func EnvStringsSnapshot(path string, maxStaleness time.Duration) option {
+	return func(e *EnvStrings) {
+		e.snapshotCache = NewSnapshotCache(path, maxStaleness)
+	}
+}
+
+// EnvStringsLockfile records the external lookups to the lockfile, or replays
+// them from it, by mode of LOCKFILE_RECORD or LOCKFILE_REPLAY
+func EnvStringsLockfile(path string, mode string) option {
+	return func(e *EnvStrings) {
+		lockfile, err := NewLockfile(path, mode)
+		if err != nil {
+			panic(err)
+		}
+		e.lockfile = lockfile
+		e.tmplFuncs.SetLockfile(lockfile)
+	}
+}
+
+// EnvStringsHTTP sets the defaults of the http funcs, such as timeout, max
+// body size, retries and allowed hosts
+func EnvStringsHTTP(conf HTTPConfig) option {
+	return func(e *EnvStrings) {
+		e.tmplFuncs.replace(NewExtFuncsHTTP(conf).GetFuncs())
+		e.httpConfigured = true
+	}
+}
+
+// EnvStringsStatistics sets how many recent calls are kept in the usage
+// statistics, the counters are kept for all calls
+func EnvStringsStatistics(size int) option {
+	return func(e *EnvStrings) {
+		e.tmplFuncs.SetStatistics(NewFuncStatistics(size))
+	}
+}
+
+// EnvStringsRedact sets the rules of masking the secrets in debug output,
+// usage statistics and errors
+func EnvStringsRedact(conf RedactConfig) option {
+	return func(e *EnvStrings) {
+		if os.Getenv(ENV_STRINGS_DEBUG_UNSAFE_KEY) == "true" {
+			conf.Unsafe = true
+		}
+		e.tmplFuncs.SetRedactor(NewRedactor(conf))
+		e.redactConfigured = true
+	}
+}
+
+// EnvStringsCache caches the results of the funcs, or of the funcs of the
+// storage engines, across the renders for the durations
+func EnvStringsCache(conf CacheConfig) option {
+	return func(e *EnvStrings) {
+		for funcName, ttl := range parseCacheTTLs(conf.Funcs) {
+			e.tmplFuncs.SetCacheTTL(funcName, ttl)
+		}
+		if conf.MaxEntries > 0 {
+			e.tmplFuncs.SetCacheMaxEntries(conf.MaxEntries)
+		}
+		e.storageCacheTTLs = parseCacheTTLs(conf.Storages)
+		e.cacheConfigured = true
+	}
+}
+
+// EnvStringsPolicies sets the timeout, retries and circuit breaker of the calls
+// of the funcs, or of the funcs of the storage engines
+func EnvStringsPolicies(conf PoliciesConfig) option {
+	return func(e *EnvStrings) {
+		for funcName, policyConf := range conf.Funcs {
+			e.tmplFuncs.SetPolicy(funcName, NewCallPolicy(policyConf))
+		}
+
+		e.storagePolicies = make(map[string]*CallPolicy, len(conf.Storages))
+		for engine, policyConf := range conf.Storages {
+			e.storagePolicies[engine] = NewCallPolicy(policyConf)
+		}
+
+		e.policyConfigured = true
+	}
+}
+
+// EnvStringsUnsafeDebug disables masking the secrets, for local debugging only
+func EnvStringsUnsafeDebug() option {
+	return func(e *EnvStrings) {
+		e.tmplFuncs.SetRedactor(NewRedactor(RedactConfig{Unsafe: true}))
+		e.redactConfigured = true
+	}
+}
+
+// WithLogger logs to the handler instead of stderr, the records are at info
+// level, or at debug level while ENV_STRINGS_DEBUG=true
+func WithLogger(handler slog.Handler) option {
+	return func(e *EnvStrings) {
+		e.setLogger(newLogger(handler))
+	}
+}
+
+// EnvStringsDecodeFormat sets the format of the rendered templates decoded by
+// ExecuteInto, DECODE_FORMAT_JSON or DECODE_FORMAT_YAML
+func EnvStringsDecodeFormat(format string) option {
+	return func(e *EnvStrings) {
+		if format != DECODE_FORMAT_JSON && format != DECODE_FORMAT_YAML {
+			panic("env_strings: unknown decode format " + format)
+		}
+		e.decodeFormat = format
+	}
+}
+
 func NewEnvStrings(envName string, envExt string, opts ...option) *EnvStrings {
 	if envName == "" {
 		panic("env_strings: env name could not be empty")
 	}
 
 	envStrings := &EnvStrings{
-		envName:    envName,
-		envExt:     envExt,
-		configFile: ENV_STRINGS_CONF,
-		tmplFuncs:  NewTemplateFuncs(),
+		envName:      envName,
+		envExt:       envExt,
+		configFile:   ENV_STRINGS_CONF,
+		tmplFuncs:    NewTemplateFuncs(),
+		decodeFormat: DECODE_FORMAT_JSON,
+	}
+
+	envStrings.setLogger(newLogger(nil))
+
+	if os.Getenv(ENV_STRINGS_DEBUG_UNSAFE_KEY) == "true" {
+		envStrings.tmplFuncs.SetRedactor(NewRedactor(RedactConfig{Unsafe: true}))
 	}
 
 	if opts != nil && len(opts) > 0 {
@@ -75,6 +218,10 @@ func NewEnvStrings(envName string, envExt string, opts ...option) *EnvStrings {
 		}
 	}
 
+	if lockfilePath := os.Getenv(ENV_STRINGS_LOCKFILE_KEY); lockfilePath != "" {
+		EnvStringsLockfile(lockfilePath, os.Getenv(ENV_STRINGS_LOCKFILE_MODE_KEY))(envStrings)
+	}
+
 	envStringsConf := os.Getenv(ENV_STRINGS_CONFIG_KEY)
 	if envStringsConf != "" {
 		envStrings.configFile = envStringsConf
@@ -89,23 +236,60 @@ func NewEnvStrings(envName string, envExt string, opts ...option) *EnvStrings {
 			}
 		}
 
+		if snapshotConf := envStrings.envConfig.Snapshot; snapshotConf != nil && snapshotConf.Path != "" && envStrings.snapshotCache == nil {
+			var maxStaleness time.Duration
+			if snapshotConf.MaxStaleness != "" {
+				var err error
+				if maxStaleness, err = time.ParseDuration(snapshotConf.MaxStaleness); err != nil {
+					panic("option of max_staleness must be duration: " + err.Error())
+				}
+			}
+			envStrings.snapshotCache = NewSnapshotCache(snapshotConf.Path, maxStaleness)
+		}
+
+		if lockfileConf := envStrings.envConfig.Lockfile; lockfileConf != nil && lockfileConf.Path != "" && envStrings.lockfile == nil {
+			EnvStringsLockfile(lockfileConf.Path, lockfileConf.Mode)(envStrings)
+		}
+
+		if httpConf := envStrings.envConfig.HTTP; httpConf != nil && !envStrings.httpConfigured {
+			EnvStringsHTTP(*httpConf)(envStrings)
+		}
+
+		if redactConf := envStrings.envConfig.Redact; redactConf != nil && !envStrings.redactConfigured {
+			EnvStringsRedact(*redactConf)(envStrings)
+		}
+
+		if cacheConf := envStrings.envConfig.Cache; cacheConf != nil && !envStrings.cacheConfigured {
+			EnvStringsCache(*cacheConf)(envStrings)
+		}
+
+		if policiesConf := envStrings.envConfig.Policies; policiesConf != nil && !envStrings.policyConfigured {
+			EnvStringsPolicies(*policiesConf)(envStrings)
+		}
+
 		if envStrings.envConfig.Storages != nil {
 			for _, storageConf := range envStrings.envConfig.Storages {
 				switch storageConf.Engine {
 				case STORAGE_REDIS:
 					{
 						extFucnRedis := NewExtFuncsRedis(storageConf.Options)
-						redisFuncs := extFucnRedis.GetFuncs()
 
-						if redisFuncs == nil {
+						if extFucnRedis.GetFuncs() == nil {
 							panic("ext funcs of redis is nil")
 						}
 
-						for funcName, fn := range redisFuncs {
-							if err := envStrings.RegisterFunc(funcName, fn); err != nil {
-								panic(err)
+						if err := envStrings.RegisterExtFuncs(extFucnRedis); err != nil {
+							panic(err)
+						}
+
+						if storageConf.Secret {
+							for funcName := range extFucnRedis.GetFuncs() {
+								envStrings.tmplFuncs.Redactor().SecretFuncs(funcName)
 							}
 						}
+
+						envStrings.setStorageCacheTTL(storageConf.Engine, extFucnRedis)
+						envStrings.setStoragePolicy(storageConf.Engine, extFucnRedis)
 					}
 				default:
 					{
@@ -118,61 +302,187 @@ func NewEnvStrings(envName string, envExt string, opts ...option) *EnvStrings {
 	return envStrings
 }
 
+// setStorageCacheTTL caches the funcs of the storage by the ttl of its engine,
+// the ttls of the funcs take precedence
+func (p *EnvStrings) setStorageCacheTTL(engine string, extFuncs ExtFuncs) {
+	ttl, exist := p.storageCacheTTLs[engine]
+	if !exist {
+		return
+	}
+
+	for funcName := range extFuncs.GetFuncs() {
+		if _, exist = p.tmplFuncs.CacheTTL(funcName); !exist {
+			p.tmplFuncs.SetCacheTTL(funcName, ttl)
+		}
+	}
+}
+
+// setStoragePolicy shares the policy of the engine by the funcs of the storage,
+// the policies of the funcs take precedence
+func (p *EnvStrings) setStoragePolicy(engine string, extFuncs ExtFuncs) {
+	policy, exist := p.storagePolicies[engine]
+	if !exist {
+		return
+	}
+
+	for funcName := range extFuncs.GetFuncs() {
+		if p.tmplFuncs.Policy(funcName) == nil {
+			p.tmplFuncs.SetPolicy(funcName, policy)
+		}
+	}
+}
+
+// prefetchStorage runs the prefetch of the storage by the policy of its engine
+func (p *EnvStrings) prefetchStorage(ctx context.Context, prefetcher ExtFuncsPrefetcher, calls []FuncCall) (template.FuncMap, error) {
+	if policy := p.storagePolicy(prefetcher); policy != nil {
+		return policy.prefetch(ctx, prefetcher, calls)
+	}
+	return prefetcher.Prefetch(ctx, calls)
+}
+
+// storagePolicy returns the policy of the engine of the storage, the policies
+// of its funcs do not apply to the storage as a whole
+func (p *EnvStrings) storagePolicy(extFuncs ExtFuncs) *CallPolicy {
+	engine, ok := extFuncs.(ExtFuncsEngine)
+	if !ok {
+		return nil
+	}
+
+	return p.storagePolicies[engine.Engine()]
+}
+
+func (p *EnvStrings) setLogger(logger *slog.Logger) {
+	p.logger = logger
+	p.tmplFuncs.SetLogger(logger)
+}
+
 func (p *EnvStrings) Execute(str string) (ret string, err error) {
 	return p.ExecuteWith(str, nil)
 }
 
 func (p *EnvStrings) ExecuteWith(str string, envValues map[string]interface{}) (ret string, err error) {
-	strEnvFiles := os.Getenv(p.envName)
+	return p.ExecuteContext(context.Background(), str, envValues)
+}
+
+// ExecuteContext executes the template, the cancellation and deadline of ctx
+// are propagated to the storages and the funcs which take a context.Context
+func (p *EnvStrings) ExecuteContext(ctx context.Context, str string, envValues map[string]interface{}) (ret string, err error) {
+	var buf bytes.Buffer
+	if err = p.execute(newRender(ctx, p.envName), str, envValues, &buf); err != nil {
+		return
+	}
+
+	ret = buf.String()
+
+	p.logger.Debug("final rendered", "env", p.envName, "rendered", p.tmplFuncs.Redactor().String(ret))
+
+	return
+}
 
-	envFiles := strings.Split(strEnvFiles, ";")
+// ExecuteTo executes the template read from r and streams the output to w,
+// the output written before an error is not reverted. The template is named
+// by the file name if r is an *os.File
+func (p *EnvStrings) ExecuteTo(w io.Writer, r io.Reader, envValues map[string]interface{}) (err error) {
+	return p.ExecuteContextTo(context.Background(), w, r, envValues)
+}
 
-	if envValues == nil {
-		envValues = make(map[string]interface{})
+func (p *EnvStrings) ExecuteContextTo(ctx context.Context, w io.Writer, r io.Reader, envValues map[string]interface{}) (err error) {
+	var data []byte
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return
 	}
 
-	debug := false
-	if os.Getenv("ENV_STRINGS_DEBUG") == "true" {
-		debug = true
+	state := newRender(ctx, p.envName)
+	if f, ok := r.(*os.File); ok {
+		state.name = f.Name()
 	}
 
-	for _, envFile := range envFiles {
+	return p.execute(state, string(data), envValues, w)
+}
 
-		if len(envFile) == 0 {
-			continue
-		}
+// ExecuteFile executes the template of the file, the errors of the template
+// are reported with the file name
+func (p *EnvStrings) ExecuteFile(fileName string) (ret string, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(fileName); err != nil {
+		return
+	}
 
-		prefix := filepath.Base(envFile)
+	r := newRender(context.Background(), p.envName)
+	r.name = fileName
 
-		prefix = filepath.Dir(prefix)
+	var buf bytes.Buffer
+	if err = p.execute(r, string(data), nil, &buf); err != nil {
+		return
+	}
 
-		err = p.loadEnv(debug, prefix, []string{envFile}, envValues)
+	ret = buf.String()
 
-		if err != nil {
+	return
+}
+
+func (p *EnvStrings) execute(r *render, str string, envValues map[string]interface{}, w io.Writer) (err error) {
+	if err = r.ctx.Err(); err != nil {
+		return
+	}
+
+	redactor := p.tmplFuncs.Redactor()
+
+	defer func() {
+		err = redactor.Error(err)
+	}()
+
+	tree := r.tree
+	if tree == nil {
+		if tree, err = p.loadTree(envValues); err != nil {
 			return
 		}
 	}
 
-	if debug {
-		debugData, _ := json.MarshalIndent(envValues, "", "    ")
-		fmt.Printf("[ENV_STRINGS] final envs:\n%s\n", string(debugData))
+	envValues = tree.values
+
+	if p.logger.Enabled(r.ctx, slog.LevelDebug) {
+		p.logger.Debug("final envs", "env", p.envName, "envs", redactor.Tree(envValues), "sources", tree.sources)
+	} else {
+		redactor.TreeSecrets(envValues)
+	}
+
+	tpl := r.tpl
+	if tpl == nil {
+		if tpl, err = template.New(r.name).Funcs(p.tmplFuncs.hookedFuncs(r)).Option("missingkey=error").Parse(str); err != nil {
+			return
+		}
+	} else {
+		tpl.Funcs(p.tmplFuncs.hookedFuncs(r))
 	}
 
-	var tpl *template.Template
+	prefetched := r.prefetched
+	if prefetched == nil && !p.replaying() {
+		// the calls are served by the storages one by one if it failed
+		prefetched, _ = p.prefetch(r.ctx, tpl)
+		r.prefetched = prefetched
+	}
 
-	if tpl, err = template.New("tmpl:" + p.envName).Funcs(p.tmplFuncs.GetFuncMaps(p.envName)).Option("missingkey=error").Parse(str); err != nil {
-		return
+	if len(prefetched) > 0 {
+		tpl.Funcs(p.tmplFuncs.hookFuncs(r, prefetched))
 	}
 
-	var buf bytes.Buffer
-	if err = tpl.Execute(&buf, envValues); err != nil {
-		return
+	if r.tracer != nil {
+		w = r.tracer.instrument(tpl, w)
 	}
 
-	ret = buf.String()
+	err = execError(tpl.Execute(w, envValues))
 
-	if debug {
-		fmt.Printf("[ENV_STRINGS] final rendered:\n%s\n", ret)
+	if p.snapshotCache != nil {
+		if e := p.snapshotCache.Flush(); e != nil {
+			p.logger.Warn("flush snapshot failure", "env", p.envName, "error", e)
+		}
+	}
+
+	if p.lockfile != nil {
+		if e := p.lockfile.Flush(); e != nil && err == nil {
+			err = e
+		}
 	}
 
 	return
@@ -188,15 +498,160 @@ func ExecuteWith(str string, envValues map[string]interface{}) (ret string, err
 	return envStrings.ExecuteWith(str, envValues)
 }
 
+func ExecuteContext(ctx context.Context, str string, envValues map[string]interface{}) (ret string, err error) {
+	envStrings := NewEnvStrings(ENV_STRINGS_KEY, ENV_STRINGS_EXT)
+	return envStrings.ExecuteContext(ctx, str, envValues)
+}
+
 func (p *EnvStrings) RegisterFunc(name string, function interface{}) (err error) {
 	return p.tmplFuncs.Register(name, function)
 }
 
+func (p *EnvStrings) RegisterExtFuncs(extFuncs ExtFuncs) (err error) {
+	funcs := extFuncs.GetFuncs()
+
+	for funcName, fn := range funcs {
+		if err = p.RegisterFunc(funcName, fn); err != nil {
+			return
+		}
+	}
+
+	for funcName := range funcs {
+		p.tmplFuncs.MarkExternal(funcName)
+	}
+
+	if versioned, ok := extFuncs.(ExtFuncsVersioned); ok && versioned.Versioned() {
+		for funcName := range funcs {
+			p.tmplFuncs.MarkVersioned(funcName)
+		}
+	}
+
+	if snapshotter, ok := extFuncs.(ExtFuncsSnapshotter); ok && p.snapshotCache != nil {
+		snapshotter.SetSnapshotCache(p.snapshotCache)
+	}
+
+	p.extFuncs = append(p.extFuncs, extFuncs)
+
+	return
+}
+
+func (p *EnvStrings) replaying() bool {
+	return p.lockfile != nil && p.lockfile.Mode() == LOCKFILE_REPLAY
+}
+
+// Watch returns the changes of the keys of the storages implementing
+// ExtFuncsWatcher, the channel is closed once all the watches stopped
+func (p *EnvStrings) Watch(ctx context.Context) (changes <-chan StorageKey, err error) {
+	var watches []<-chan StorageKey
+
+	for _, extFuncs := range p.extFuncs {
+		watcher, ok := extFuncs.(ExtFuncsWatcher)
+		if !ok {
+			continue
+		}
+
+		var watch <-chan StorageKey
+		if watch, err = watcher.Watch(ctx); err != nil {
+			return
+		}
+
+		watches = append(watches, watch)
+	}
+
+	if len(watches) == 0 {
+		err = fmt.Errorf("no storage of env %s could be watched", p.envName)
+		return
+	}
+
+	out := make(chan StorageKey)
+
+	var wg sync.WaitGroup
+	for _, watch := range watches {
+		wg.Add(1)
+		go func(watch <-chan StorageKey) {
+			defer wg.Done()
+			for change := range watch {
+				select {
+				case out <- change:
+				case <-ctx.Done():
+				}
+			}
+		}(watch)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	changes = out
+
+	return
+}
+
+// prefetch fetches the storage values of the calls with literal args in one
+// round trip per storage, the calls cached across the renders are skipped. The
+// calls which are not prefetched or failed to prefetch will be served by the
+// storage on call
+func (p *EnvStrings) prefetch(ctx context.Context, tpls ...*template.Template) (funcs template.FuncMap, err error) {
+	var errs []error
+
+	for _, extFuncs := range p.extFuncs {
+		prefetcher, ok := extFuncs.(ExtFuncsPrefetcher)
+		if !ok {
+			continue
+		}
+
+		var calls []FuncCall
+		for _, tpl := range tpls {
+			for _, call := range literalCalls(tpl, prefetcher.GetFuncs()) {
+				if !p.tmplFuncs.cached(call) {
+					calls = append(calls, call)
+				}
+			}
+		}
+
+		if len(calls) == 0 {
+			continue
+		}
+
+		prefetchedFuncs, e := p.prefetchStorage(ctx, prefetcher, calls)
+		if e != nil {
+			p.logger.Warn("prefetch failure", "env", p.envName, "calls", len(calls), "error", p.tmplFuncs.Redactor().Error(e))
+			errs = append(errs, e)
+			continue
+		}
+
+		if funcs == nil {
+			funcs = make(template.FuncMap)
+		}
+
+		for funcName, fn := range prefetchedFuncs {
+			funcs[funcName] = fn
+		}
+	}
+
+	err = errors.Join(errs...)
+
+	return
+}
+
+// FuncUsageStatic returns a copy of the recent calls by env name
 func (p *EnvStrings) FuncUsageStatic() map[string][]FuncStaticItem {
-	return funcStatics
+	statics := make(map[string][]FuncStaticItem)
+	for _, item := range p.tmplFuncs.Statistics().Items() {
+		statics[item.EnvName] = append(statics[item.EnvName], item)
+	}
+	return statics
+}
+
+// FuncStatistics returns the usage statistics of the funcs of this instance,
+// which could be snapshot, reset or exported as json
+func (p *EnvStrings) FuncStatistics() *FuncStatistics {
+	return p.tmplFuncs.Statistics()
 }
 
-func (p *EnvStrings) loadEnv(debug bool, prefix string, files []string, envs map[string]interface{}) (err error) {
+func (p *EnvStrings) loadEnv(tree *envTree, keys []string, files []string, envs map[string]interface{}) (err error) {
 	for _, path := range files {
 
 		var fi os.FileInfo
@@ -244,7 +699,7 @@ func (p *EnvStrings) loadEnv(debug bool, prefix string, files []string, envs map
 				nextENVs = preEnvs.(map[string]interface{})
 			}
 
-			err = p.loadEnv(debug, prefix, nextfiles, nextENVs)
+			err = p.loadEnv(tree, appendKey(keys, baseName), nextfiles, nextENVs)
 			if err != nil {
 				return
 			}
@@ -261,33 +716,39 @@ func (p *EnvStrings) loadEnv(debug bool, prefix string, files []string, envs map
 			return err
 		}
 
-		if debug {
-			debugData, _ := json.MarshalIndent(fileEnvs, "", "    ")
-			fmt.Printf("[ENV_STRINGS] env file: %s\n%s\n", path, string(debugData))
+		if p.logger.Enabled(context.Background(), slog.LevelDebug) {
+			p.logger.Debug("env file loaded", "env", p.envName, "file", path, "envs", p.tmplFuncs.Redactor().Tree(fileEnvs))
 		}
 
 		if envs == nil {
 			envs = make(map[string]interface{})
 		}
 
+		fileKeys := appendKey(keys, baseName)
+
 		existEnvs, exist := envs[baseName]
 
 		if exist {
-			err = mergeMaps(existEnvs, fileEnvs)
+			var conflict []string
+			conflict, err = mergeMaps(fileKeys, existEnvs, fileEnvs)
 			if err != nil {
-				err = fmt.Errorf("merge same env key's values failure, file: %s, error: %s", path, err.Error())
+				conflictPath := joinKeyPath(conflict)
+				err = &MergeConflictError{File: path, Path: conflictPath, Previous: tree.previous(conflictPath)}
 				return
 			}
 
 		} else {
 			envs[baseName] = fileEnvs
 		}
+
+		tree.record(fileKeys, fileEnvs, path)
 	}
 
 	return
 }
 
-func mergeMaps(vA, vB interface{}) (err error) {
+// mergeMaps merges vB into vA, and returns the keys where they conflict
+func mergeMaps(keys []string, vA, vB interface{}) (conflict []string, err error) {
 	vAMap, okA := vA.(map[string]interface{})
 	vBMap, okB := vB.(map[string]interface{})
 
@@ -297,7 +758,7 @@ func mergeMaps(vA, vB interface{}) (err error) {
 			if !exist {
 				vAMap[k] = valB
 			} else {
-				err = mergeMaps(valA, valB)
+				conflict, err = mergeMaps(appendKey(keys, k), valA, valB)
 				if err != nil {
 					return
 				}
@@ -307,6 +768,7 @@ func mergeMaps(vA, vB interface{}) (err error) {
 		return
 	}
 
+	conflict = keys
 	err = fmt.Errorf("could not merge different struct values")
 
 	return
@@ -326,6 +788,7 @@ func (p *EnvStrings) loadEnvFile(filename string) (ret map[string]interface{}, e
 
 		err = json.Unmarshal(data, &r)
 		if err != nil {
+			err = &DecodeError{File: filename, Err: err}
 			return
 		}
 
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gogap/env_strings"
+)
+
+const usage = `usage: env_strings <command> [options]
+
+commands:
+  coverage    report the env and storage keys referenced by templates
+  daemon      re-render the templates of a manifest on changes
+  render      render the templates of a manifest
+  render-dir  render the *.tmpl files of a dir into a dest dir
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch os.Args[1] {
+	case "coverage":
+		{
+			err = coverage(os.Args[2:])
+		}
+	case "daemon":
+		{
+			err = daemon(os.Args[2:])
+		}
+	case "render":
+		{
+			err = render(os.Args[2:])
+		}
+	case "render-dir":
+		{
+			err = renderDir(os.Args[2:])
+		}
+	default:
+		{
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+	}
+
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
+
+func newFlagSet(name string) (flags *flag.FlagSet, envName, envExt *string) {
+	flags = flag.NewFlagSet(name, flag.ExitOnError)
+	envName = flags.String("env", env_strings.ENV_STRINGS_KEY, "the name of the env var listing the env files")
+	envExt = flags.String("ext", env_strings.ENV_STRINGS_EXT, "the extension of the env files")
+	return
+}
+
+func coverage(args []string) (err error) {
+	flags, envName, envExt := newFlagSet("coverage")
+	asJSON := flags.Bool("json", false, "print the report as json")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: env_strings coverage [options] <template files...>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	envStrings := env_strings.NewEnvStrings(*envName, *envExt)
+
+	var report *env_strings.CoverageReport
+	if report, err = envStrings.Coverage(flags.Args()...); err != nil {
+		return
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(report)
+	}
+
+	unusedKeys := report.UnusedKeys()
+	fmt.Printf("env keys: %d/%d used\n", len(report.Keys)-len(unusedKeys), len(report.Keys))
+	for _, path := range unusedKeys {
+		fmt.Printf("  unused: %s\n", path)
+	}
+
+	unusedStorageKeys := report.UnusedStorageKeys()
+	fmt.Printf("storage keys: %d/%d used\n", len(report.StorageKeys)-len(unusedStorageKeys), len(report.StorageKeys))
+	for _, key := range unusedStorageKeys {
+		fmt.Printf("  unused: %s\n", key)
+	}
+	for _, e := range report.StorageErrors {
+		fmt.Printf("  failed: %s\n", e)
+	}
+
+	if len(report.Dynamic) > 0 {
+		fmt.Println("dynamic references:")
+		for _, ref := range report.Dynamic {
+			fmt.Printf("  %s: %s: %s\n", ref.Position, ref.Node, ref.Reason)
+		}
+	}
+
+	return
+}
+
+func render(args []string) (err error) {
+	flags, envName, envExt := newFlagSet("render")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: env_strings render [options] <manifest>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var manifest *env_strings.RenderManifest
+	if manifest, err = env_strings.LoadRenderManifest(flags.Arg(0)); err != nil {
+		return
+	}
+
+	envStrings := env_strings.NewEnvStrings(*envName, *envExt)
+
+	results, err := envStrings.RenderManifest(manifest)
+	printResults(results)
+
+	return
+}
+
+func printResults(results []env_strings.RenderResult) {
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			{
+				fmt.Printf("failed %s -> %s: %s\n", result.Template, result.Output, result.Error)
+			}
+		case result.Reloaded:
+			{
+				fmt.Printf("rendered %s -> %s, reloaded\n", result.Template, result.Output)
+			}
+		case result.Changed:
+			{
+				fmt.Printf("rendered %s -> %s\n", result.Template, result.Output)
+			}
+		default:
+			{
+				fmt.Printf("unchanged %s\n", result.Output)
+			}
+		}
+	}
+}
+
+func renderDir(args []string) (err error) {
+	flags, envName, envExt := newFlagSet("render-dir")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: env_strings render-dir [options] <src dir> <dest dir>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	envStrings := env_strings.NewEnvStrings(*envName, *envExt)
+
+	results, err := envStrings.RenderDir(flags.Arg(0), flags.Arg(1))
+	printResults(results)
+
+	return
+}
+
+func daemon(args []string) (err error) {
+	flags, envName, envExt := newFlagSet("daemon")
+	interval := flags.Duration("interval", env_strings.DAEMON_DEFAULT_INTERVAL, "the interval to poll the env files and the storages")
+	minInterval := flags.Duration("min-interval", env_strings.DAEMON_DEFAULT_MIN_INTERVAL, "the min interval between two renders")
+	health := flags.String("health", "", "the address to serve the health status, such as :9090")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: env_strings daemon [options] <manifest>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var manifest *env_strings.RenderManifest
+	if manifest, err = env_strings.LoadRenderManifest(flags.Arg(0)); err != nil {
+		return
+	}
+
+	envStrings := env_strings.NewEnvStrings(*envName, *envExt, env_strings.WithLogger(slog.NewTextHandler(os.Stderr, nil)))
+
+	d := envStrings.NewDaemon(manifest, *interval, *minInterval)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *health != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", d)
+
+		server := &http.Server{Addr: *health, Handler: mux}
+		go func() {
+			if e := server.ListenAndServe(); e != nil && e != http.ErrServerClosed {
+				log.Println(e)
+				stop()
+			}
+		}()
+		defer server.Close()
+	}
+
+	return d.Run(ctx)
+}
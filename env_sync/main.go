@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 
 	"github.com/gogap/env_strings"
 	"github.com/hoisie/redis"
@@ -16,14 +18,18 @@ import (
 
 var (
 	client redis.Client
+
+	errKeysChanged = errors.New("keys changed")
 )
 
 type redisConfig struct {
-	db       int32
-	password string
-	poolSize int32
-	address  string
-	prefix   string
+	db         int32
+	password   string
+	poolSize   int32
+	address    string
+	prefix     string
+	versionKey string
+	channel    string
 }
 
 type syncData struct {
@@ -49,11 +55,24 @@ func main() {
 		log.Println(err)
 		os.Exit(1)
 	}
-	err = set(data)
+	var version string
+	var changed bool
+	if config.versionKey != "" {
+		version, changed, err = setVersion(config, data)
+	} else {
+		changed, err = set(keyPrefix(config.prefix, ""), data)
+	}
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
+	if changed && config.channel != "" {
+		err = client.Publish(config.channel, []byte(version))
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
 	return
 }
 
@@ -124,6 +143,24 @@ func getRedisConfig() (config redisConfig, err error) {
 			} else {
 				config.prefix = strPrefix
 			}
+
+			if v, exist := storage.Options["version_key"]; !exist {
+				config.versionKey = ""
+			} else if strVersionKey, ok := v.(string); !ok {
+				err = errors.New("option of version_key must be string")
+				return
+			} else {
+				config.versionKey = strVersionKey
+			}
+
+			if v, exist := storage.Options["channel"]; !exist {
+				config.channel = ""
+			} else if strChannel, ok := v.(string); !ok {
+				err = errors.New("option of channel must be string")
+				return
+			} else {
+				config.channel = strChannel
+			}
 		} else {
 			err = errors.New("storage only support redis now")
 		}
@@ -195,27 +232,167 @@ func serializeObject(obj interface{}) (str string, err error) {
 	return
 }
 
-func set(data []syncData) (err error) {
+// keyPrefix returns the prefix of the keys of a generation, the keys are
+// stored as <prefix>/<version>/<key> like the redis storage reads them
+func keyPrefix(prefix, version string) (ret string) {
+	for _, part := range []string{prefix, version} {
+		if part != "" {
+			ret += part + "/"
+		}
+	}
+	return
+}
+
+// setVersion writes the data as a new generation if it changed from the
+// current one, then points the version key to it. The generation before the
+// current one is deleted, the current one is kept for the renders reading it
+func setVersion(config redisConfig, data []syncData) (version string, changed bool, err error) {
+	versionKey := keyPrefix(config.prefix, "") + config.versionKey
+
+	current := 0
+	if v, e := client.Get(versionKey); e == nil {
+		if current, err = strconv.Atoi(string(v)); err != nil {
+			err = fmt.Errorf("version of %s must be int: %s", versionKey, err.Error())
+			return
+		}
+	}
+
+	if current > 0 {
+		if changed, err = diff(keyPrefix(config.prefix, strconv.Itoa(current)), data); err != nil || !changed {
+			return
+		}
+	}
+
+	version = strconv.Itoa(current + 1)
+
+	if _, err = set(keyPrefix(config.prefix, version), data); err != nil {
+		return
+	}
+
+	if err = client.Set(versionKey, []byte(version)); err != nil {
+		return
+	}
+
+	changed = true
+
+	if current > 1 {
+		err = deleteKeys(keyPrefix(config.prefix, strconv.Itoa(current-1)))
+	}
+
+	return
+}
+
+// diff reports whether any data differs from the keys under the prefix, or
+// any key or field under the prefix was removed from the data
+func diff(prefix string, data []syncData) (changed bool, err error) {
+	for _, v := range data {
+		var origin []byte
+		var e error
+		if v.field == "" {
+			origin, e = client.Get(prefix + v.key)
+		} else {
+			origin, e = client.Hget(prefix+v.key, v.field)
+		}
+		if e != nil || string(origin) != v.value {
+			return true, nil
+		}
+	}
+	return diffKeys(prefix, data)
+}
+
+// diffKeys reports whether the keys under the prefix by SCAN, or the fields of
+// the hash keys by HLEN, are more than the data
+func diffKeys(prefix string, data []syncData) (changed bool, err error) {
+	fields := make(map[string]int)
+	for _, v := range data {
+		if v.field == "" {
+			fields[prefix+v.key] = 0
+		} else {
+			fields[prefix+v.key]++
+		}
+	}
+
+	var conn *env_strings.RedisConn
+	if conn, err = env_strings.DialRedis(context.Background(), client); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Scan(prefix+"*", func(keys []string) (e error) {
+		var cmds [][]string
+		var hashKeys []string
+		for _, key := range keys {
+			count, exist := fields[key]
+			if !exist {
+				return errKeysChanged
+			}
+			if count > 0 {
+				cmds = append(cmds, []string{"HLEN", key})
+				hashKeys = append(hashKeys, key)
+			}
+		}
+
+		if len(cmds) == 0 {
+			return
+		}
+
+		var replies []interface{}
+		if replies, e = conn.Pipeline(cmds...); e != nil {
+			return
+		}
+
+		for i, key := range hashKeys {
+			if count, _ := replies[i].(int64); int(count) != fields[key] {
+				return errKeysChanged
+			}
+		}
+
+		return
+	})
+
+	if errors.Is(err, errKeysChanged) {
+		changed, err = true, nil
+	}
+
+	return
+}
+
+// deleteKeys unlinks the keys under the prefix by the batches of SCAN
+func deleteKeys(prefix string) (err error) {
+	var conn *env_strings.RedisConn
+	if conn, err = env_strings.DialRedis(context.Background(), client); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	return conn.Scan(prefix+"*", func(keys []string) (e error) {
+		_, e = conn.Pipeline(append([]string{"UNLINK"}, keys...))
+		return
+	})
+}
+
+func set(prefix string, data []syncData) (changed bool, err error) {
 	for _, v := range data {
 		if v.field == "" {
-			origin, e := client.Get(v.key)
+			origin, e := client.Get(prefix + v.key)
 			if e == nil && string(origin) == v.value {
 				continue
 			}
-			err = client.Set(v.key, []byte(v.value))
+			err = client.Set(prefix+v.key, []byte(v.value))
 			if err != nil {
 				return
 			}
 		} else {
-			origin, e := client.Hget(v.key, v.field)
+			origin, e := client.Hget(prefix+v.key, v.field)
 			if e == nil && string(origin) == v.value {
 				continue
 			}
-			_, err = client.Hset(v.key, v.field, []byte(v.value))
+			_, err = client.Hset(prefix+v.key, v.field, []byte(v.value))
 			if err != nil {
 				return
 			}
 		}
+		changed = true
 	}
 	return
 }
@@ -0,0 +1,276 @@
+package env_strings
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	ENV_VALUES_SOURCE = "<values>"
+)
+
+// Provenance is the source of a leaf key of the env tree, the file which set
+// it, or ENV_VALUES_SOURCE for the values given to ExecuteWith. A leaf is never
+// overridden, the files setting the same leaf fail to merge, and the error
+// names both of them
+type Provenance struct {
+	Path   string `json:"path"`
+	Source string `json:"source"`
+}
+
+type envTree struct {
+	values  map[string]interface{}
+	sources map[string]string
+}
+
+func newEnvTree(values map[string]interface{}) *envTree {
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+
+	tree := &envTree{
+		values:  values,
+		sources: make(map[string]string),
+	}
+
+	for k, v := range values {
+		tree.record([]string{k}, v, ENV_VALUES_SOURCE)
+	}
+
+	return tree
+}
+
+// record sets the source of every leaf under the keys
+func (p *envTree) record(keys []string, v interface{}, source string) {
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, child := range m {
+			p.record(appendKey(keys, k), child, source)
+		}
+		return
+	}
+
+	p.sources[joinKeyPath(keys)] = source
+}
+
+// previous returns the sources of the leaves set before at or under the path
+func (p *envTree) previous(path string) string {
+	var sources []string
+	seen := make(map[string]bool)
+
+	for _, provenance := range p.explain(path) {
+		if !seen[provenance.Source] {
+			seen[provenance.Source] = true
+			sources = append(sources, provenance.Source)
+		}
+	}
+
+	return strings.Join(sources, ";")
+}
+
+func (p *envTree) explain(path string) (provenances []Provenance) {
+	if source, exist := p.sources[path]; exist {
+		return []Provenance{{Path: path, Source: source}}
+	}
+
+	prefix := path + "."
+	if path == "" {
+		prefix = ""
+	}
+
+	for leaf, source := range p.sources {
+		if strings.HasPrefix(leaf, prefix) {
+			provenances = append(provenances, Provenance{Path: leaf, Source: source})
+		}
+	}
+
+	sort.Slice(provenances, func(i, j int) bool {
+		return provenances[i].Path < provenances[j].Path
+	})
+
+	return
+}
+
+// Explain returns the sources of the leaf keys under the path of the env tree,
+// the keys of the path are joined by "." and the dots in the keys are escaped
+// as "\."
+func (p *EnvStrings) Explain(path string) (provenances []Provenance, err error) {
+	var tree *envTree
+	if tree, err = p.loadTree(nil); err != nil {
+		return
+	}
+
+	if provenances = tree.explain(path); len(provenances) == 0 {
+		err = fmt.Errorf("key %s not found in env %s", path, p.envName)
+		return
+	}
+
+	return
+}
+
+// Tree loads the env files and returns the merged env tree, the tree is loaded
+// again on every call
+func (p *EnvStrings) Tree() (tree map[string]interface{}, err error) {
+	var t *envTree
+	if t, err = p.loadTree(nil); err != nil {
+		return
+	}
+
+	tree = t.values
+
+	return
+}
+
+// Get returns the value at the path of the env tree, the keys of the path are
+// joined by "." and the dots in the keys are escaped as "\."
+func (p *EnvStrings) Get(path string) (value interface{}, err error) {
+	var tree map[string]interface{}
+	if tree, err = p.Tree(); err != nil {
+		return
+	}
+
+	value = tree
+
+	for i, key := range splitKeyPath(path) {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			err = fmt.Errorf("value of %s in env %s is not a map", joinKeyPath(splitKeyPath(path)[:i]), p.envName)
+			return
+		}
+
+		if value, ok = m[key]; !ok {
+			err = &MissingKeyError{Path: path, Key: key}
+			return
+		}
+	}
+
+	return
+}
+
+// Keys returns the paths of the leaf keys under the prefix, sorted
+func (p *EnvStrings) Keys(prefix string) (paths []string, err error) {
+	var tree *envTree
+	if tree, err = p.loadTree(nil); err != nil {
+		return
+	}
+
+	for _, provenance := range tree.explain(prefix) {
+		paths = append(paths, provenance.Path)
+	}
+
+	return
+}
+
+// Walk calls the fn with the path and the value of every leaf key of the env
+// tree in the order of the paths, it stops at the first error of the fn
+func (p *EnvStrings) Walk(fn func(path string, value interface{}) error) (err error) {
+	var tree *envTree
+	if tree, err = p.loadTree(nil); err != nil {
+		return
+	}
+
+	for _, provenance := range tree.explain("") {
+		var value interface{} = tree.values
+		for _, key := range splitKeyPath(provenance.Path) {
+			value = value.(map[string]interface{})[key]
+		}
+
+		if err = fn(provenance.Path, value); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// GetAs returns the value at the path converted to T, the values which are not
+// of T are converted through json, such as the numbers to int or the maps to
+// structs
+func GetAs[T any](envStrings *EnvStrings, path string) (ret T, err error) {
+	var value interface{}
+	if value, err = envStrings.Get(path); err != nil {
+		return
+	}
+
+	if v, ok := value.(T); ok {
+		ret = v
+		return
+	}
+
+	var data []byte
+	if data, err = json.Marshal(value); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(data, &ret); err != nil {
+		err = fmt.Errorf("convert value of %s in env %s to %T failure: %s", path, envStrings.envName, ret, err.Error())
+		return
+	}
+
+	return
+}
+
+func (p *EnvStrings) loadTree(envValues map[string]interface{}) (tree *envTree, err error) {
+	tree = newEnvTree(envValues)
+
+	for _, envFile := range strings.Split(os.Getenv(p.envName), ";") {
+		if len(envFile) == 0 {
+			continue
+		}
+
+		if err = p.loadEnv(tree, nil, []string{envFile}, tree.values); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func appendKey(keys []string, key string) []string {
+	next := make([]string, len(keys), len(keys)+1)
+	copy(next, keys)
+	return append(next, key)
+}
+
+func escapeKey(key string) string {
+	key = strings.Replace(key, `\`, `\\`, -1)
+	return strings.Replace(key, `.`, `\.`, -1)
+}
+
+func joinKeyPath(keys []string) string {
+	escaped := make([]string, len(keys))
+	for i, key := range keys {
+		escaped[i] = escapeKey(key)
+	}
+	return strings.Join(escaped, ".")
+}
+
+func splitKeyPath(path string) (keys []string) {
+	if path == "" {
+		return
+	}
+
+	var key strings.Builder
+	escaped := false
+
+	for _, c := range path {
+		switch {
+		case escaped:
+			key.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '.':
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteRune(c)
+		}
+	}
+
+	keys = append(keys, key.String())
+
+	return
+}
@@ -0,0 +1,215 @@
+package env_strings
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"text/template"
+)
+
+var (
+	ErrMissingKey         = errors.New("missing key")
+	ErrBackendUnavailable = errors.New("storage backend unavailable")
+	ErrFuncFailure        = errors.New("func failure")
+	ErrDecode             = errors.New("decode failure")
+	ErrMergeConflict      = errors.New("merge conflict")
+	ErrRequired           = errors.New("required value is empty")
+)
+
+var (
+	execErrorRegexp  = regexp.MustCompile(`^template: (.*?:\d+:\d+): executing "[^"]*" at <(.*?)>: `)
+	missingKeyRegexp = regexp.MustCompile(`map has no entry for key "([^"]*)"`)
+)
+
+// MissingKeyError is a key of the template which is not in the env tree, Path
+// is the node of the template such as .db.host
+type MissingKeyError struct {
+	Path     string
+	Key      string
+	Position string
+	Err      error
+}
+
+func (p *MissingKeyError) Error() string {
+	if p.Err != nil {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("map has no entry for key %q of %s", p.Key, p.Path)
+}
+
+func (p *MissingKeyError) Is(target error) bool {
+	return target == ErrMissingKey
+}
+
+func (p *MissingKeyError) Unwrap() error {
+	return p.Err
+}
+
+// BackendError is a failure of a storage backend, it is ErrBackendUnavailable
+// if the backend could not be reached, rather than answered with an error
+type BackendError struct {
+	Engine      string
+	Key         string
+	Field       string
+	Unavailable bool
+	Err         error
+}
+
+func (p *BackendError) Error() string {
+	msg := p.Err.Error()
+	if p.Key != "" {
+		msg += ", key: " + p.Key
+	}
+	if p.Field != "" {
+		msg += ", field: " + p.Field
+	}
+	return msg
+}
+
+func (p *BackendError) Is(target error) bool {
+	return target == ErrBackendUnavailable && p.Unavailable
+}
+
+func (p *BackendError) Unwrap() error {
+	return p.Err
+}
+
+// FuncError is a failure of a func called by the template, Position is set
+// once the template fails with it
+type FuncError struct {
+	Func     string
+	EnvName  string
+	Args     []interface{}
+	Position string
+	Err      error
+}
+
+func (p *FuncError) Error() string {
+	return p.Err.Error()
+}
+
+func (p *FuncError) Is(target error) bool {
+	return target == ErrFuncFailure
+}
+
+func (p *FuncError) Unwrap() error {
+	return p.Err
+}
+
+// DecodeError is a failure to decode an env file, or a rendered template in
+// ExecuteInto, with the position and the action of the template which
+// rendered the offending value if it is known
+type DecodeError struct {
+	File     string
+	Position string
+	Node     string
+	Err      error
+}
+
+func (p *DecodeError) Error() string {
+	if p.File != "" {
+		return fmt.Sprintf("decode env file %s failure: %s", p.File, p.Err.Error())
+	}
+	if p.Position != "" {
+		return fmt.Sprintf("%s: %s: decode rendered template failure: %s", p.Position, p.Node, p.Err.Error())
+	}
+	return fmt.Sprintf("decode rendered template failure: %s", p.Err.Error())
+}
+
+func (p *DecodeError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+func (p *DecodeError) Unwrap() error {
+	return p.Err
+}
+
+// MergeConflictError is a leaf of the env tree set by more than one file
+type MergeConflictError struct {
+	File     string
+	Path     string
+	Previous string
+}
+
+func (p *MergeConflictError) Error() string {
+	return fmt.Sprintf("merge same env key's values failure, file: %s, key: %s, previous: %s, error: could not merge different struct values", p.File, p.Path, p.Previous)
+}
+
+func (p *MergeConflictError) Is(target error) bool {
+	return target == ErrMergeConflict
+}
+
+// FieldError is a failure to render a string of RenderStruct, Path is the
+// path of the field such as DB.Hosts[0] or Labels["env"]
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (p *FieldError) Error() string {
+	return fmt.Sprintf("render field %s failure: %s", p.Path, p.Err.Error())
+}
+
+func (p *FieldError) Unwrap() error {
+	return p.Err
+}
+
+// CommandError is a failure of the check or the reload command of a template,
+// the output is kept as it was if the check failed, and Diff is the change
+// which was rejected
+type CommandError struct {
+	Stage    string
+	Template string
+	Cmd      string
+	Output   string
+	Diff     string
+	Err      error
+}
+
+func (p *CommandError) Error() string {
+	msg := fmt.Sprintf("%s command of %s failure: %s, cmd: %s", p.Stage, p.Template, p.Err.Error(), p.Cmd)
+	if p.Output != "" {
+		msg += "\noutput:\n" + p.Output
+	}
+	if p.Diff != "" {
+		msg += "\ndiff:\n" + p.Diff
+	}
+	return msg
+}
+
+func (p *CommandError) Unwrap() error {
+	return p.Err
+}
+
+// execError fills the template position into the errors of the execution, a
+// missing key is returned as *MissingKeyError
+func execError(err error) error {
+	var execErr template.ExecError
+	if !errors.As(err, &execErr) {
+		return err
+	}
+
+	matches := execErrorRegexp.FindStringSubmatch(err.Error())
+	if matches == nil {
+		return err
+	}
+
+	position, node := matches[1], matches[2]
+
+	var funcErr *FuncError
+	if errors.As(err, &funcErr) {
+		funcErr.Position = position
+		return err
+	}
+
+	if keyMatches := missingKeyRegexp.FindStringSubmatch(err.Error()); keyMatches != nil {
+		return &MissingKeyError{
+			Path:     node,
+			Key:      keyMatches[1],
+			Position: position,
+			Err:      err,
+		}
+	}
+
+	return err
+}
@@ -1,6 +1,7 @@
 package env_strings
 
 import (
+	"context"
 	"text/template"
 )
 
@@ -9,3 +10,68 @@ type ExtFunc func(args ...interface{}) (ret interface{}, err error)
 type ExtFuncs interface {
 	GetFuncs() template.FuncMap
 }
+
+// FuncCall is a call found in a template whose arguments are all string literals
+type FuncCall struct {
+	Name string
+	Args []string
+}
+
+// ExtFuncsPrefetcher is implemented by ext funcs which could fetch the values of
+// statically known calls in one round trip, the returned funcs serve the calls
+// of one render from the fetched values
+type ExtFuncsPrefetcher interface {
+	ExtFuncs
+	Prefetch(ctx context.Context, calls []FuncCall) (funcs template.FuncMap, err error)
+}
+
+// ExtFuncsSnapshotter is implemented by ext funcs which could fall back to the
+// last known good values while the backend is unavailable
+type ExtFuncsSnapshotter interface {
+	ExtFuncs
+	SetSnapshotCache(cache *SnapshotCache)
+}
+
+// ExtFuncsEngine is implemented by the ext funcs of a storage engine, such as
+// STORAGE_REDIS, the policy of the engine bounds the storage as a whole
+type ExtFuncsEngine interface {
+	ExtFuncs
+	Engine() string
+}
+
+// ExtFuncsVersioned is implemented by ext funcs whose values are read by
+// generations of their storage, the results of their funcs are not cached
+// across the renders while it is versioned, so the generations never mix
+type ExtFuncsVersioned interface {
+	ExtFuncs
+	Versioned() bool
+}
+
+// StorageKey is a key of a storage, the field is set for the keys of a hash
+type StorageKey struct {
+	Engine string `json:"engine"`
+	Key    string `json:"key"`
+	Field  string `json:"field,omitempty"`
+}
+
+func (p StorageKey) String() string {
+	if p.Field != "" {
+		return p.Engine + ":" + p.Key + " " + p.Field
+	}
+	return p.Engine + ":" + p.Key
+}
+
+// ExtFuncsKeyLister is implemented by ext funcs which could list the keys of
+// their storage, and tell the key read by a call with literal args
+type ExtFuncsKeyLister interface {
+	ExtFuncs
+	ListKeys(ctx context.Context) (keys []StorageKey, err error)
+	CallKey(call FuncCall) (key StorageKey, ok bool)
+}
+
+// ExtFuncsWatcher is implemented by ext funcs whose storage could notify the
+// changes of its keys, the channel is closed once the watch stopped
+type ExtFuncsWatcher interface {
+	ExtFuncs
+	Watch(ctx context.Context) (changes <-chan StorageKey, err error)
+}
@@ -0,0 +1,265 @@
+package env_strings
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	HTTP_DEFAULT_TIMEOUT       = 30 * time.Second
+	HTTP_DEFAULT_MAX_BODY_SIZE = 10 << 20
+	HTTP_DEFAULT_RETRY_BACKOFF = 200 * time.Millisecond
+	HTTP_MAX_REDIRECTS         = 10
+)
+
+var errHostNotAllowed = errors.New("host is not allowed")
+
+type HTTPConfig struct {
+	Timeout      string            `json:"timeout"`
+	MaxBodySize  int64             `json:"max_body_size"`
+	Retries      int               `json:"retries"`
+	RetryBackoff string            `json:"retry_backoff"`
+	AllowedHosts []string          `json:"allowed_hosts"`
+	Headers      map[string]string `json:"headers"`
+}
+
+// HTTPHeader is a request header given to the http funcs by http_header,
+// http_basic_auth or http_bearer
+type HTTPHeader struct {
+	Name  string
+	Value string
+}
+
+type ExtFuncsHTTP struct {
+	client       *http.Client
+	maxBodySize  int64
+	retries      int
+	retryBackoff time.Duration
+	allowedHosts []string
+	headers      map[string]string
+}
+
+func NewExtFuncsHTTP(conf HTTPConfig) ExtFuncs {
+	funcs := &ExtFuncsHTTP{
+		client:       &http.Client{Timeout: HTTP_DEFAULT_TIMEOUT},
+		maxBodySize:  HTTP_DEFAULT_MAX_BODY_SIZE,
+		retries:      conf.Retries,
+		retryBackoff: HTTP_DEFAULT_RETRY_BACKOFF,
+		allowedHosts: conf.AllowedHosts,
+		headers:      conf.Headers,
+	}
+
+	funcs.client.CheckRedirect = funcs.checkRedirect
+
+	if conf.Timeout != "" {
+		timeout, err := time.ParseDuration(conf.Timeout)
+		if err != nil {
+			panic("option of timeout must be duration: " + err.Error())
+		}
+		funcs.client.Timeout = timeout
+	}
+
+	if conf.MaxBodySize > 0 {
+		funcs.maxBodySize = conf.MaxBodySize
+	}
+
+	if conf.RetryBackoff != "" {
+		backoff, err := time.ParseDuration(conf.RetryBackoff)
+		if err != nil {
+			panic("option of retry_backoff must be duration: " + err.Error())
+		}
+		funcs.retryBackoff = backoff
+	}
+
+	return funcs
+}
+
+func (p *ExtFuncsHTTP) GetFuncs() template.FuncMap {
+	funcs := make(template.FuncMap)
+
+	funcs["httpGet"] = p.Get
+	funcs["http_get"] = p.Get
+	funcs["http_json"] = p.GetJSON
+	funcs["http_header"] = httpHeader
+	funcs["http_basic_auth"] = httpBasicAuth
+	funcs["http_bearer"] = httpBearer
+
+	return funcs
+}
+
+func (p *ExtFuncsHTTP) Get(ctx context.Context, rawURL string, headers ...HTTPHeader) (ret string, err error) {
+	var body []byte
+	if body, err = p.get(ctx, rawURL, headers); err != nil {
+		return
+	}
+
+	ret = string(body)
+
+	return
+}
+
+func (p *ExtFuncsHTTP) GetJSON(ctx context.Context, rawURL string, headers ...HTTPHeader) (ret interface{}, err error) {
+	var body []byte
+	if body, err = p.get(ctx, rawURL, headers); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(body, &ret); err != nil {
+		err = fmt.Errorf("decode json of %s failure: %s", rawURL, err.Error())
+		return
+	}
+
+	return
+}
+
+func (p *ExtFuncsHTTP) get(ctx context.Context, rawURL string, headers []HTTPHeader) (body []byte, err error) {
+	var u *url.URL
+	if u, err = url.Parse(rawURL); err != nil {
+		return
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("unsupported scheme of url %s", rawURL)
+		return
+	}
+
+	if !p.allowed(u) {
+		err = fmt.Errorf("%w: %s", errHostNotAllowed, u.Host)
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if body, retry, err = p.do(ctx, rawURL, headers); err == nil || !retry || attempt >= p.retries {
+			return
+		}
+
+		backoff := p.retryBackoff << uint(attempt)
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (p *ExtFuncsHTTP) do(ctx context.Context, rawURL string, headers []HTTPHeader) (body []byte, retry bool, err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil); err != nil {
+		return
+	}
+
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+
+	for _, header := range headers {
+		req.Header.Set(header.Name, header.Value)
+	}
+
+	defer func() {
+		if err != nil {
+			err = &BackendError{Engine: "http", Key: rawURL, Unavailable: retry, Err: err}
+		}
+	}()
+
+	var resp *http.Response
+	if resp, err = p.client.Do(req); err != nil {
+		retry = ctx.Err() == nil && !errors.Is(err, errHostNotAllowed)
+		return
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, p.maxBodySize))
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		err = fmt.Errorf("http get failure, status: %s", resp.Status)
+		return
+	}
+
+	if body, err = ioutil.ReadAll(io.LimitReader(resp.Body, p.maxBodySize+1)); err != nil {
+		retry = ctx.Err() == nil
+		return
+	}
+
+	if int64(len(body)) > p.maxBodySize {
+		body = nil
+		err = fmt.Errorf("http get failure, body exceeds %d bytes", p.maxBodySize)
+		return
+	}
+
+	return
+}
+
+// checkRedirect checks the allowed hosts on every hop, so an allowed host
+// could not redirect the request and its headers to a host not allowed
+func (p *ExtFuncsHTTP) checkRedirect(req *http.Request, via []*http.Request) error {
+	if !p.allowed(req.URL) {
+		return fmt.Errorf("redirect to %w: %s", errHostNotAllowed, req.URL.Host)
+	}
+
+	if len(via) >= HTTP_MAX_REDIRECTS {
+		return fmt.Errorf("stopped after %d redirects", HTTP_MAX_REDIRECTS)
+	}
+
+	return nil
+}
+
+// allowed reports whether the host of the url matches one of the allowed
+// hosts, the patterns such as *.example.com are matched as path.Match
+func (p *ExtFuncsHTTP) allowed(u *url.URL) bool {
+	if len(p.allowedHosts) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.allowedHosts {
+		pattern = strings.ToLower(pattern)
+
+		for _, host := range []string{strings.ToLower(u.Host), strings.ToLower(u.Hostname())} {
+			if matched, _ := path.Match(pattern, host); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func httpHeader(name, value string) (header HTTPHeader, err error) {
+	if name == "" {
+		err = errors.New("header name could not be empty")
+		return
+	}
+
+	header = HTTPHeader{Name: name, Value: value}
+
+	return
+}
+
+func httpBasicAuth(user, password string) HTTPHeader {
+	return HTTPHeader{
+		Name:  "Authorization",
+		Value: "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)),
+	}
+}
+
+func httpBearer(token string) HTTPHeader {
+	return HTTPHeader{
+		Name:  "Authorization",
+		Value: "Bearer " + token,
+	}
+}
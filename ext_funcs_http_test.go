@@ -0,0 +1,296 @@
+package env_strings
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestExtFuncsHTTP(conf HTTPConfig) *ExtFuncsHTTP {
+	return NewExtFuncsHTTP(conf).(*ExtFuncsHTTP)
+}
+
+func testHost(t *testing.T, server *httptest.Server) string {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func TestExtFuncsHTTPGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	ret, err := newTestExtFuncsHTTP(HTTPConfig{}).Get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ret != "hello" {
+		t.Fatalf("expect hello, got %q", ret)
+	}
+}
+
+func TestExtFuncsHTTPTimeout(t *testing.T) {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+
+	_, err := newTestExtFuncsHTTP(HTTPConfig{Timeout: "50ms"}).Get(context.Background(), server.URL)
+	if err == nil {
+		t.Fatal("expect timeout error")
+	}
+
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("expect backend unavailable, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout took %s", elapsed)
+	}
+}
+
+func TestExtFuncsHTTPStatus(t *testing.T) {
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := newTestExtFuncsHTTP(HTTPConfig{Retries: 3, RetryBackoff: "1ms"}).Get(context.Background(), server.URL)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expect status error, got %v", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expect 4xx not retried, got %d calls", calls)
+	}
+}
+
+func TestExtFuncsHTTPMaxBodySize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	defer server.Close()
+
+	funcs := newTestExtFuncsHTTP(HTTPConfig{MaxBodySize: 4})
+
+	if ret, err := funcs.Get(context.Background(), server.URL+"?body=1234"); err != nil || ret != "1234" {
+		t.Fatalf("expect body within max size, got %q, %v", ret, err)
+	}
+
+	if _, err := funcs.Get(context.Background(), server.URL+"?body=12345"); err == nil || !strings.Contains(err.Error(), "exceeds 4 bytes") {
+		t.Fatalf("expect max body size error, got %v", err)
+	}
+}
+
+func TestExtFuncsHTTPRetry(t *testing.T) {
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ret, err := newTestExtFuncsHTTP(HTTPConfig{Retries: 2, RetryBackoff: "1ms"}).Get(context.Background(), server.URL)
+	if err != nil || ret != "ok" {
+		t.Fatalf("expect ok after retries, got %q, %v", ret, err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("expect 3 calls, got %d", calls)
+	}
+
+	atomic.StoreInt32(&calls, -10)
+
+	if _, err = newTestExtFuncsHTTP(HTTPConfig{Retries: 1, RetryBackoff: "1ms"}).Get(context.Background(), server.URL); err == nil {
+		t.Fatal("expect error after retries exhausted")
+	}
+
+	if calls != -8 {
+		t.Fatalf("expect 2 calls, got %d", calls+10)
+	}
+}
+
+func TestExtFuncsHTTPRetryBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+
+	newTestExtFuncsHTTP(HTTPConfig{Retries: 2, RetryBackoff: "20ms"}).Get(context.Background(), server.URL)
+
+	// 20ms before the first retry and 40ms before the second
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("expect backoff of 60ms, got %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	if _, err := newTestExtFuncsHTTP(HTTPConfig{Retries: 5, RetryBackoff: time.Hour.String()}).Get(ctx, server.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect backoff stopped by ctx, got %v", err)
+	}
+}
+
+func TestExtFuncsHTTPAllowedHosts(t *testing.T) {
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	if _, err := newTestExtFuncsHTTP(HTTPConfig{AllowedHosts: []string{testHost(t, server)}}).Get(context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newTestExtFuncsHTTP(HTTPConfig{AllowedHosts: []string{"*.example.com"}}).Get(context.Background(), server.URL); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expect host not allowed, got %v", err)
+	}
+
+	if _, err := newTestExtFuncsHTTP(HTTPConfig{}).Get(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Fatal("expect unsupported scheme error")
+	}
+
+	if calls != 1 {
+		t.Fatalf("expect 1 call, got %d", calls)
+	}
+}
+
+func TestExtFuncsHTTPRedirectAllowedHosts(t *testing.T) {
+	var leaked int32
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&leaked, 1)
+		w.Write([]byte("leaked"))
+	}))
+	defer other.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			w.Write([]byte("internal"))
+			return
+		}
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	}))
+	defer server.Close()
+
+	funcs := newTestExtFuncsHTTP(HTTPConfig{Retries: 2, RetryBackoff: "1ms", AllowedHosts: []string{testHost(t, server)}})
+
+	if ret, err := funcs.Get(context.Background(), server.URL+"?to=/internal"); err != nil || ret != "internal" {
+		t.Fatalf("expect redirect within allowed host, got %q, %v", ret, err)
+	}
+
+	_, err := funcs.Get(context.Background(), server.URL+"?to="+url.QueryEscape(other.URL), httpBearer("token"))
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expect redirect not allowed, got %v", err)
+	}
+
+	if leaked != 0 {
+		t.Fatalf("expect no request to the host not allowed, got %d", leaked)
+	}
+}
+
+func TestExtFuncsHTTPHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		w.Write([]byte(r.Header.Get("User-Agent") + "," + r.Header.Get("X-Trace") + "," + user + ":" + password))
+	}))
+	defer server.Close()
+
+	funcs := newTestExtFuncsHTTP(HTTPConfig{Headers: map[string]string{"User-Agent": "env_strings", "X-Trace": "default"}})
+
+	header, err := httpHeader("X-Trace", "call")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := funcs.Get(context.Background(), server.URL, header, httpBasicAuth("user", "pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ret != "env_strings,call,user:pass" {
+		t.Fatalf("unexpected headers %q", ret)
+	}
+
+	if _, err = httpHeader("", "value"); err == nil {
+		t.Fatal("expect empty header name error")
+	}
+}
+
+func TestExtFuncsHTTPBearer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	funcs := newTestExtFuncsHTTP(HTTPConfig{})
+
+	if _, err := funcs.Get(context.Background(), server.URL); err == nil {
+		t.Fatal("expect unauthorized error")
+	}
+
+	if ret, err := funcs.Get(context.Background(), server.URL, httpBearer("token")); err != nil || ret != "ok" {
+		t.Fatalf("expect ok, got %q, %v", ret, err)
+	}
+}
+
+func TestExtFuncsHTTPGetJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			w.Write([]byte(`{"data":`))
+			return
+		}
+		w.Write([]byte(`{"data":{"token":"abc","ttl":60}}`))
+	}))
+	defer server.Close()
+
+	funcs := newTestExtFuncsHTTP(HTTPConfig{})
+
+	ret, err := funcs.GetJSON(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ret.(map[string]interface{})["data"].(map[string]interface{})
+	if data["token"] != "abc" || data["ttl"] != float64(60) {
+		t.Fatalf("unexpected json %v", ret)
+	}
+
+	if _, err = funcs.GetJSON(context.Background(), server.URL+"/bad"); err == nil || !strings.Contains(err.Error(), "decode json") {
+		t.Fatalf("expect decode error, got %v", err)
+	}
+}
@@ -1,16 +1,22 @@
 package env_strings
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"text/template"
 
 	"github.com/hoisie/redis"
 )
 
 type ExtFuncsRedis struct {
-	client redis.Client
-	prefix string
+	client     redis.Client
+	prefix     string
+	versionKey string
+	channel    string
+	snapshot   *SnapshotCache
 }
 
 func NewExtFuncsRedis(options map[string]interface{}) ExtFuncs {
@@ -63,6 +69,24 @@ func NewExtFuncsRedis(options map[string]interface{}) ExtFuncs {
 		prefix = strPrefix
 	}
 
+	var versionKey string
+	if v, exist := options["version_key"]; !exist {
+		versionKey = ""
+	} else if strVersionKey, ok := v.(string); !ok {
+		panic("option of version_key must be string")
+	} else {
+		versionKey = strVersionKey
+	}
+
+	var channel string
+	if v, exist := options["channel"]; !exist {
+		channel = ""
+	} else if strChannel, ok := v.(string); !ok {
+		panic("option of channel must be string")
+	} else {
+		channel = strChannel
+	}
+
 	client := redis.Client{
 		Addr:        addr,
 		Db:          int(db),
@@ -72,6 +96,8 @@ func NewExtFuncsRedis(options map[string]interface{}) ExtFuncs {
 
 	storage.client = client
 	storage.prefix = prefix
+	storage.versionKey = versionKey
+	storage.channel = channel
 
 	return storage
 }
@@ -79,13 +105,438 @@ func NewExtFuncsRedis(options map[string]interface{}) ExtFuncs {
 func (p *ExtFuncsRedis) GetFuncs() template.FuncMap {
 	funcs := make(template.FuncMap)
 
-	funcs["redis_get"] = p.Get
-	funcs["redis_hget"] = p.HGet
+	funcs["redis_get"] = p.GetContext
+	funcs["redis_hget"] = p.HGetContext
 
 	return funcs
 }
 
+// Prefetch reads the version, then the values of all the calls in one pipeline
+// of MGET and HMGETs. Nothing is read without calls, the generation of the
+// render is then read by its first lookup
+func (p *ExtFuncsRedis) Prefetch(ctx context.Context, calls []FuncCall) (funcs template.FuncMap, err error) {
+	if len(calls) == 0 {
+		return
+	}
+
+	snapshot := newRedisSnapshot()
+
+	var conn *RedisConn
+	if conn, err = DialRedis(ctx, p.client); err != nil {
+		err = p.backendError(err, "", "")
+		return
+	}
+	defer conn.Close()
+
+	var version string
+	if version, err = p.pipelineVersion(conn); err != nil {
+		return
+	}
+
+	// the calls not prefetched read the same generation
+	snapshot.version = p.pinVersion(ctx, version)
+
+	var keys []string
+	var hashKeys []string
+	hashFields := make(map[string][]string)
+
+	for _, call := range calls {
+		switch call.Name {
+		case "redis_get":
+			{
+				if len(call.Args) < 1 || call.Args[0] == "" {
+					continue
+				}
+
+				key := p.versionedKey(snapshot.version, call.Args[0])
+				if _, exist := snapshot.values[key]; !exist {
+					snapshot.values[key] = nil
+					keys = append(keys, key)
+				}
+			}
+		case "redis_hget":
+			{
+				if len(call.Args) < 2 || call.Args[0] == "" || call.Args[1] == "" {
+					continue
+				}
+
+				key := p.versionedKey(snapshot.version, call.Args[0])
+				fields, exist := snapshot.hashes[key]
+				if !exist {
+					fields = make(map[string][]byte)
+					snapshot.hashes[key] = fields
+					hashKeys = append(hashKeys, key)
+				}
+
+				if _, exist := fields[call.Args[1]]; !exist {
+					fields[call.Args[1]] = nil
+					hashFields[key] = append(hashFields[key], call.Args[1])
+				}
+			}
+		}
+	}
+
+	var cmds [][]string
+	if len(keys) > 0 {
+		cmds = append(cmds, append([]string{"MGET"}, keys...))
+	}
+	for _, key := range hashKeys {
+		cmds = append(cmds, append([]string{"HMGET", key}, hashFields[key]...))
+	}
+
+	if len(cmds) > 0 {
+		var replies []interface{}
+		if replies, err = conn.Pipeline(cmds...); err != nil {
+			err = p.backendError(err, "", "")
+			return
+		}
+
+		if len(keys) > 0 {
+			values, _ := replies[0].([]interface{})
+			for i, key := range keys {
+				if i < len(values) {
+					snapshot.values[key], _ = values[i].([]byte)
+				}
+			}
+			replies = replies[1:]
+		}
+
+		for i, key := range hashKeys {
+			values, _ := replies[i].([]interface{})
+			for j, field := range hashFields[key] {
+				if j < len(values) {
+					snapshot.hashes[key][field], _ = values[j].([]byte)
+				}
+			}
+		}
+	}
+
+	funcs = make(template.FuncMap)
+
+	funcs["redis_get"] = func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		return p.get(ctx, snapshot, args...)
+	}
+
+	funcs["redis_hget"] = func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		return p.hget(ctx, snapshot, args...)
+	}
+
+	return
+}
+
+// ListKeys lists the keys of the current generation under the prefix, the
+// string keys and the fields of the hash keys, the keys of other types are
+// skipped. The keys are iterated by SCAN, and the types and the fields of each
+// batch are read by a pipeline
+func (p *ExtFuncsRedis) ListKeys(ctx context.Context) (keys []StorageKey, err error) {
+	var conn *RedisConn
+	if conn, err = DialRedis(ctx, p.client); err != nil {
+		err = p.backendError(err, "", "")
+		return
+	}
+	defer conn.Close()
+
+	var version string
+	if version, err = p.pipelineVersion(conn); err != nil {
+		return
+	}
+
+	pattern := p.versionedKey(version, "*")
+	prefix := strings.TrimSuffix(pattern, "*")
+
+	if err = conn.Scan(pattern, func(names []string) (e error) {
+		cmds := make([][]string, len(names))
+		for i, name := range names {
+			cmds[i] = []string{"TYPE", name}
+		}
+
+		var types []interface{}
+		if types, e = conn.Pipeline(cmds...); e != nil {
+			return
+		}
+
+		var hashNames []string
+		cmds = cmds[:0]
+
+		for i, name := range names {
+			switch types[i] {
+			case "string":
+				{
+					keys = append(keys, StorageKey{Engine: STORAGE_REDIS, Key: strings.TrimPrefix(name, prefix)})
+				}
+			case "hash":
+				{
+					hashNames = append(hashNames, name)
+					cmds = append(cmds, []string{"HKEYS", name})
+				}
+			}
+		}
+
+		if len(cmds) == 0 {
+			return
+		}
+
+		var replies []interface{}
+		if replies, e = conn.Pipeline(cmds...); e != nil {
+			return
+		}
+
+		for i, name := range hashNames {
+			fields, _ := replies[i].([]interface{})
+			for _, field := range fields {
+				if f, ok := field.([]byte); ok {
+					keys = append(keys, StorageKey{Engine: STORAGE_REDIS, Key: strings.TrimPrefix(name, prefix), Field: string(f)})
+				}
+			}
+		}
+
+		return
+	}); err != nil {
+		err = p.backendError(err, pattern, "")
+		return
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Key != keys[j].Key {
+			return keys[i].Key < keys[j].Key
+		}
+		return keys[i].Field < keys[j].Field
+	})
+
+	return
+}
+
+func (p *ExtFuncsRedis) CallKey(call FuncCall) (key StorageKey, ok bool) {
+	switch call.Name {
+	case "redis_get":
+		{
+			if len(call.Args) < 1 || call.Args[0] == "" {
+				return
+			}
+			key = StorageKey{Engine: STORAGE_REDIS, Key: call.Args[0]}
+		}
+	case "redis_hget":
+		{
+			if len(call.Args) < 2 || call.Args[0] == "" || call.Args[1] == "" {
+				return
+			}
+			key = StorageKey{Engine: STORAGE_REDIS, Key: call.Args[0], Field: call.Args[1]}
+		}
+	default:
+		return
+	}
+
+	ok = true
+
+	return
+}
+
+// Watch subscribes the channel which env_sync publishes to after each sync if
+// the channel is set, and an empty key is sent for every sync. Otherwise it
+// subscribes the keyspace notifications of the keys under the prefix, or of
+// the version key if it is set, the server must enable them by
+// notify-keyspace-events, such as "Kgh$"
+func (p *ExtFuncsRedis) Watch(ctx context.Context) (changes <-chan StorageKey, err error) {
+	channelPrefix := fmt.Sprintf("__keyspace@%d__:", p.client.Db)
+
+	pattern := channelPrefix + p.key("*")
+	if p.versionKey != "" {
+		pattern = channelPrefix + p.key(p.versionKey)
+	}
+
+	channel, isPattern := p.channel, false
+	if channel == "" {
+		channel, isPattern = pattern, true
+	}
+
+	// the connection is closed once the ctx is done, which ends the subscription
+	var conn *RedisConn
+	if conn, err = DialRedis(ctx, p.client); err != nil {
+		return
+	}
+
+	out := make(chan StorageKey)
+
+	go func() {
+		defer close(out)
+		defer conn.Close()
+
+		conn.Subscribe(channel, isPattern, func(msgChannel string) {
+			change := StorageKey{Engine: STORAGE_REDIS}
+
+			if p.channel == "" && p.versionKey == "" {
+				change.Key = strings.TrimPrefix(strings.TrimPrefix(msgChannel, channelPrefix), p.key(""))
+			}
+
+			select {
+			case out <- change:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	changes = out
+
+	return
+}
+
 func (p *ExtFuncsRedis) Get(args ...interface{}) (ret interface{}, err error) {
+	return p.get(context.Background(), nil, args...)
+}
+
+func (p *ExtFuncsRedis) HGet(args ...interface{}) (ret interface{}, err error) {
+	return p.hget(context.Background(), nil, args...)
+}
+
+func (p *ExtFuncsRedis) GetContext(ctx context.Context, args ...interface{}) (ret interface{}, err error) {
+	return p.get(ctx, nil, args...)
+}
+
+func (p *ExtFuncsRedis) HGetContext(ctx context.Context, args ...interface{}) (ret interface{}, err error) {
+	return p.hget(ctx, nil, args...)
+}
+
+// do runs the command until it returns or the ctx is done, the client could not
+// be interrupted, so the command is left to finish in the background
+func (p *ExtFuncsRedis) do(ctx context.Context, fn func() error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+func (p *ExtFuncsRedis) Engine() string {
+	return STORAGE_REDIS
+}
+
+// Versioned reports whether the values are read by the generation of the
+// version key
+func (p *ExtFuncsRedis) Versioned() bool {
+	return p.versionKey != ""
+}
+
+func (p *ExtFuncsRedis) SetSnapshotCache(cache *SnapshotCache) {
+	p.snapshot = cache
+}
+
+func (p *ExtFuncsRedis) key(key string) string {
+	if p.prefix != "" {
+		return p.prefix + "/" + key
+	}
+	return key
+}
+
+// versionedKey returns the key of the generation, the generations are stored
+// under the prefix as <prefix>/<version>/<key>
+func (p *ExtFuncsRedis) versionedKey(version, key string) string {
+	if version != "" {
+		return p.key(version + "/" + key)
+	}
+	return p.key(key)
+}
+
+type redisVersionKey struct {
+	storage *ExtFuncsRedis
+}
+
+// renderVersion returns the generation of the render, it is read once per
+// render scope of the ctx
+func (p *ExtFuncsRedis) renderVersion(ctx context.Context) (version string, err error) {
+	var v interface{}
+	if v, err = scopeValue(ctx, redisVersionKey{p}, func() (interface{}, error) {
+		return p.version(ctx)
+	}); err != nil {
+		return
+	}
+
+	version = v.(string)
+
+	return
+}
+
+// pinVersion sets the generation of the render scope of the ctx, unless it
+// was read already
+func (p *ExtFuncsRedis) pinVersion(ctx context.Context, version string) string {
+	if v, err := scopeValue(ctx, redisVersionKey{p}, func() (interface{}, error) {
+		return version, nil
+	}); err == nil {
+		version = v.(string)
+	}
+	return version
+}
+
+// version returns the current generation of the values, empty if there is no
+// version key or it is not set
+func (p *ExtFuncsRedis) version(ctx context.Context) (version string, err error) {
+	if p.versionKey == "" {
+		return
+	}
+
+	var v []byte
+	if err = p.do(ctx, func() (e error) {
+		v, e = p.client.Get(p.key(p.versionKey))
+		return
+	}); err != nil {
+		var redisErr redis.RedisError
+		if errors.As(err, &redisErr) {
+			err = nil
+			return
+		}
+		err = p.backendError(err, p.key(p.versionKey), "")
+		return
+	}
+
+	version = string(v)
+
+	return
+}
+
+// pipelineVersion reads the version on the conn, as version does by the client
+func (p *ExtFuncsRedis) pipelineVersion(conn *RedisConn) (version string, err error) {
+	if p.versionKey == "" {
+		return
+	}
+
+	var replies []interface{}
+	if replies, err = conn.Pipeline([]string{"GET", p.key(p.versionKey)}); err != nil {
+		err = p.backendError(err, p.key(p.versionKey), "")
+		return
+	}
+
+	v, _ := replies[0].([]byte)
+	version = string(v)
+
+	return
+}
+
+// resolve returns the key of the generation of the snapshot, or of the
+// generation of the render if there is no snapshot
+func (p *ExtFuncsRedis) resolve(ctx context.Context, snapshot *redisSnapshot, key string) (versioned string, err error) {
+	version := ""
+	if snapshot != nil {
+		version = snapshot.version
+	} else if version, err = p.renderVersion(ctx); err != nil {
+		return
+	}
+
+	versioned = p.versionedKey(version, key)
+
+	return
+}
+
+func (p *ExtFuncsRedis) get(ctx context.Context, snapshot *redisSnapshot, args ...interface{}) (ret interface{}, err error) {
 	if len(args) < 1 {
 		err = errors.New("args need 1 or 2 args")
 		return
@@ -98,24 +549,42 @@ func (p *ExtFuncsRedis) Get(args ...interface{}) (ret interface{}, err error) {
 		return
 	}
 
-	if p.prefix != "" {
-		key = p.prefix + "/" + key
+	versioned, e := p.resolve(ctx, snapshot, key)
+
+	var v []byte
+
+	if e == nil {
+		if snapshot != nil && snapshot.hasKey(versioned) {
+			v, e = snapshot.get(versioned)
+		} else {
+			e = p.do(ctx, func() (err error) {
+				v, err = p.client.Get(versioned)
+				return
+			})
+		}
 	}
 
-	if v, e := p.client.Get(key); e != nil {
-		if len(args) >= 2 {
+	if e != nil {
+		if versioned == "" {
+			versioned = p.key(key)
+		}
+
+		if stale, exist := p.stale(e, key, ""); exist {
+			ret = stale
+		} else if len(args) >= 2 {
 			ret = args[1]
 		} else {
-			err = e
+			err = p.backendError(e, versioned, "")
 		}
 		return
 	} else {
 		ret = string(v)
+		p.remember(key, "", ret.(string))
 	}
 	return
 }
 
-func (p *ExtFuncsRedis) HGet(args ...interface{}) (ret interface{}, err error) {
+func (p *ExtFuncsRedis) hget(ctx context.Context, snapshot *redisSnapshot, args ...interface{}) (ret interface{}, err error) {
 	if len(args) < 2 {
 		err = errors.New("args need 2 or 3 args")
 		return
@@ -128,27 +597,123 @@ func (p *ExtFuncsRedis) HGet(args ...interface{}) (ret interface{}, err error) {
 		return
 	}
 
-	if p.prefix != "" {
-		key = p.prefix + "/" + key
-	}
-
 	field := args[1].(string)
 
 	if field == "" {
-		err = fmt.Errorf("field could not be empty, key: %s", key)
+		err = fmt.Errorf("field could not be empty, key: %s", p.key(key))
 		return
 	}
 
-	if v, e := p.client.Hget(key, field); e != nil {
-		if len(args) >= 3 {
+	versioned, e := p.resolve(ctx, snapshot, key)
+
+	var v []byte
+
+	if e == nil {
+		if snapshot != nil && snapshot.hasField(versioned, field) {
+			v, e = snapshot.hget(versioned, field)
+		} else {
+			e = p.do(ctx, func() (err error) {
+				v, err = p.client.Hget(versioned, field)
+				return
+			})
+		}
+	}
+
+	if e != nil {
+		if versioned == "" {
+			versioned = p.key(key)
+		}
+
+		if stale, exist := p.stale(e, key, field); exist {
+			ret = stale
+			return
+		} else if len(args) >= 3 {
 			ret = args[2]
 			return
 		} else {
-			err = fmt.Errorf("%s, key: %s, field: %s", e.Error(), key, field)
+			err = p.backendError(e, versioned, field)
 			return
 		}
 	} else {
 		ret = string(v)
+		p.remember(key, field, ret.(string))
 	}
 	return
 }
+
+// remember keeps the value in the last known good snapshot by the key without
+// the generation, so the snapshot holds one value of each key whatever the
+// generations synced
+func (p *ExtFuncsRedis) remember(key, field, value string) {
+	if p.snapshot != nil {
+		p.snapshot.Put(STORAGE_REDIS, p.key(key), field, value)
+	}
+}
+
+func (p *ExtFuncsRedis) stale(e error, key, field string) (value StaleValue, exist bool) {
+	if p.snapshot == nil || !isRedisUnavailable(e) {
+		return
+	}
+
+	return p.snapshot.Get(STORAGE_REDIS, p.key(key), field)
+}
+
+func (p *ExtFuncsRedis) backendError(e error, key, field string) error {
+	return &BackendError{
+		Engine:      STORAGE_REDIS,
+		Key:         key,
+		Field:       field,
+		Unavailable: isRedisUnavailable(e),
+		Err:         e,
+	}
+}
+
+// isRedisUnavailable reports whether the error came from the connection rather
+// than from redis itself, such as a key which does not exist, a deadline is
+// taken as the backend unavailable but a cancellation is not
+func isRedisUnavailable(e error) bool {
+	var redisErr redis.RedisError
+	return e != nil && !errors.As(e, &redisErr) && !errors.Is(e, context.Canceled)
+}
+
+// redisSnapshot holds the values of one generation fetched by Prefetch, a nil
+// value means the key or field does not exist
+type redisSnapshot struct {
+	version string
+	values  map[string][]byte
+	hashes  map[string]map[string][]byte
+}
+
+func newRedisSnapshot() *redisSnapshot {
+	return &redisSnapshot{
+		values: make(map[string][]byte),
+		hashes: make(map[string]map[string][]byte),
+	}
+}
+
+func (p *redisSnapshot) hasKey(key string) bool {
+	_, exist := p.values[key]
+	return exist
+}
+
+func (p *redisSnapshot) hasField(key, field string) bool {
+	if fields, exist := p.hashes[key]; exist {
+		_, exist = fields[field]
+		return exist
+	}
+	return false
+}
+
+func (p *redisSnapshot) get(key string) ([]byte, error) {
+	if v := p.values[key]; v != nil {
+		return v, nil
+	}
+	return nil, redis.RedisError("Key `" + key + "` does not exist")
+}
+
+func (p *redisSnapshot) hget(key, field string) ([]byte, error) {
+	if v := p.hashes[key][field]; v != nil {
+		return v, nil
+	}
+	return nil, redis.RedisError("Key `" + key + "` does not exist")
+}
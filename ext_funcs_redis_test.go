@@ -0,0 +1,267 @@
+package env_strings
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRedis serves the string and hash values by the commands of the redis
+// protocol used by the storage, and records the commands it received
+type testRedis struct {
+	addr string
+
+	locker      sync.Mutex
+	values      map[string]string
+	hashes      map[string]map[string]string
+	subscribers map[string][]*bufio.Writer
+	cmds        []string
+}
+
+func newTestRedis(t *testing.T) *testRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	server := &testRedis{
+		addr:        l.Addr().String(),
+		values:      make(map[string]string),
+		hashes:      make(map[string]map[string]string),
+		subscribers: make(map[string][]*bufio.Writer),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (p *testRedis) commands() []string {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return append([]string(nil), p.cmds...)
+}
+
+func (p *testRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, "*") {
+			return
+		}
+
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+
+		args := make([]string, n)
+		for i := range args {
+			if line, err = r.ReadString('\n'); err != nil {
+				return
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			data := make([]byte, size+2)
+			if _, err = io.ReadFull(r, data); err != nil {
+				return
+			}
+			args[i] = string(data[:size])
+		}
+
+		p.locker.Lock()
+		p.cmds = append(p.cmds, strings.Join(args, " "))
+		if strings.ToUpper(args[0]) == "SUBSCRIBE" {
+			p.subscribers[args[1]] = append(p.subscribers[args[1]], w)
+			fmt.Fprintf(w, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		} else {
+			p.reply(w, args)
+		}
+		if r.Buffered() == 0 {
+			w.Flush()
+		}
+		p.locker.Unlock()
+	}
+}
+
+// publish sends the message to the subscribers of the channel, and returns
+// how many received it
+func (p *testRedis) publish(channel, message string) (n int) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	for _, w := range p.subscribers[channel] {
+		fmt.Fprintf(w, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(channel), channel, len(message), message)
+		if w.Flush() == nil {
+			n++
+		}
+	}
+
+	return
+}
+
+func (p *testRedis) reply(w io.Writer, args []string) {
+	bulk := func(v string, exist bool) {
+		if !exist {
+			fmt.Fprint(w, "$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "AUTH", "SELECT":
+		{
+			fmt.Fprint(w, "+OK\r\n")
+		}
+	case "GET":
+		{
+			v, exist := p.values[args[1]]
+			bulk(v, exist)
+		}
+	case "MGET":
+		{
+			fmt.Fprintf(w, "*%d\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				v, exist := p.values[key]
+				bulk(v, exist)
+			}
+		}
+	case "HMGET":
+		{
+			fmt.Fprintf(w, "*%d\r\n", len(args)-2)
+			for _, field := range args[2:] {
+				v, exist := p.hashes[args[1]][field]
+				bulk(v, exist)
+			}
+		}
+	default:
+		{
+			fmt.Fprintf(w, "-ERR unknown command %s\r\n", args[0])
+		}
+	}
+}
+
+func newTestRedisEnvStrings(t *testing.T, conf string) *EnvStrings {
+	confFile := filepath.Join(t.TempDir(), "env_strings.conf")
+	if err := ioutil.WriteFile(confFile, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(ENV_STRINGS_CONFIG_KEY, confFile)
+	t.Setenv("ENV_STRINGS_REDIS_TEST", "")
+
+	return NewEnvStrings("ENV_STRINGS_REDIS_TEST", ENV_STRINGS_EXT)
+}
+
+func TestExtFuncsRedisCachedByStorage(t *testing.T) {
+	server := newTestRedis(t)
+	server.values["host"] = "db.local"
+	server.hashes["db"] = map[string]string{"port": "5432"}
+
+	envStrings := newTestRedisEnvStrings(t, `{
+		"storages": [{"engine": "redis", "options": {"address": "`+server.addr+`"}}],
+		"cache": {"storages": {"redis": "1m"}}
+	}`)
+
+	for i := 0; i < 2; i++ {
+		ret, err := envStrings.Execute(`{{redis_get "host"}}:{{redis_hget "db" "port"}}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ret != "db.local:5432" {
+			t.Fatalf("expect db.local:5432, got %q", ret)
+		}
+	}
+
+	if cmds := server.commands(); len(cmds) != 2 {
+		t.Fatalf("expect the values fetched once, got %q", cmds)
+	}
+
+	if items := envStrings.FuncStatistics().Items(); len(items) != 4 || items[2].Cache != CACHE_HIT || items[3].Cache != CACHE_HIT {
+		t.Fatalf("expect the second render served by the cache, got %+v", items)
+	}
+}
+
+func TestExtFuncsRedisVersionedNotCached(t *testing.T) {
+	server := newTestRedis(t)
+	server.values["version"] = "1"
+	server.values["1/host"] = "db1.local"
+
+	envStrings := newTestRedisEnvStrings(t, `{
+		"storages": [{"engine": "redis", "options": {"address": "`+server.addr+`", "version_key": "version"}}],
+		"cache": {"storages": {"redis": "1m"}}
+	}`)
+
+	if ret, err := envStrings.Execute(`{{redis_get "host"}}`); err != nil || ret != "db1.local" {
+		t.Fatalf("expect db1.local, got %q, %v", ret, err)
+	}
+
+	server.locker.Lock()
+	server.values["version"] = "2"
+	server.values["2/host"] = "db2.local"
+	server.locker.Unlock()
+
+	if ret, err := envStrings.Execute(`{{redis_get "host"}}`); err != nil || ret != "db2.local" {
+		t.Fatalf("expect db2.local of the new generation, got %q, %v", ret, err)
+	}
+}
+
+func TestExtFuncsRedisWatchStopsWithCtx(t *testing.T) {
+	server := newTestRedis(t)
+
+	envStrings := newTestRedisEnvStrings(t, `{
+		"storages": [{"engine": "redis", "options": {"address": "`+server.addr+`", "channel": "env_sync"}}]
+	}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := envStrings.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for server.publish("env_sync", "synced") == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect a change after the sync")
+	}
+
+	cancel()
+
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expect the changes closed once the ctx is done")
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package env_strings
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// chownAs changes the owner of the file to the owner of the file info, it is
+// best effort if not running as root, which could not give a file away
+func chownAs(fileName string, info os.FileInfo) (err error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	var current os.FileInfo
+	if current, err = os.Lstat(fileName); err != nil {
+		return
+	}
+
+	if currentStat, ok := current.Sys().(*syscall.Stat_t); ok && currentStat.Uid == stat.Uid && currentStat.Gid == stat.Gid {
+		return
+	}
+
+	if err = os.Chown(fileName, int(stat.Uid), int(stat.Gid)); errors.Is(err, os.ErrPermission) && os.Geteuid() != 0 {
+		err = nil
+	}
+
+	return
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package env_strings
+
+import (
+	"os"
+)
+
+// chownAs does nothing, the files have no uid and gid on windows
+func chownAs(fileName string, info os.FileInfo) (err error) {
+	return
+}
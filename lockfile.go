@@ -0,0 +1,261 @@
+package env_strings
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	LOCKFILE_RECORD = "record"
+	LOCKFILE_REPLAY = "replay"
+
+	ENV_STRINGS_LOCKFILE_KEY      = "ENV_STRINGS_LOCKFILE"
+	ENV_STRINGS_LOCKFILE_MODE_KEY = "ENV_STRINGS_LOCKFILE_MODE"
+)
+
+type LockfileConfig struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+// lockEntry is the result of a call, the args are kept by their sha256 only,
+// as they may be secrets such as the tokens of the http headers
+type lockEntry struct {
+	Func  string          `json:"func"`
+	Args  string          `json:"args_sha256"`
+	Type  string          `json:"type,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Lockfile records the results of the external lookups made while rendering,
+// the last result of each call is kept, and replays them without touching the
+// network or clock
+type Lockfile struct {
+	path string
+	mode string
+
+	locker  sync.Mutex
+	loaded  bool
+	loadErr error
+	entries []lockEntry
+	indexes map[string]int
+	replays map[string][]lockEntry
+	cursors map[string]int
+}
+
+func NewLockfile(path string, mode string) (lockfile *Lockfile, err error) {
+	if mode != LOCKFILE_RECORD && mode != LOCKFILE_REPLAY {
+		err = fmt.Errorf("unknown lockfile mode: %s", mode)
+		return
+	}
+
+	if path == "" {
+		err = errors.New("lockfile path could not be empty")
+		return
+	}
+
+	lockfile = &Lockfile{
+		path:    path,
+		mode:    mode,
+		indexes: make(map[string]int),
+		replays: make(map[string][]lockEntry),
+		cursors: make(map[string]int),
+	}
+
+	return
+}
+
+func (p *Lockfile) Mode() string {
+	return p.mode
+}
+
+// Record keeps the result of the call in place of its previous one, and
+// returns the value as it will be replayed, so the recorded and replayed
+// renders are the same
+func (p *Lockfile) Record(funcName string, args []interface{}, ret interface{}, callErr error) (value interface{}, err error) {
+	entry := lockEntry{Func: funcName}
+
+	if entry.Args, err = lockArgs(args); err != nil {
+		return
+	}
+
+	if callErr != nil {
+		entry.Error = callErr.Error()
+	} else if entry.Type, entry.Value, err = encodeLockValue(ret); err != nil {
+		return
+	} else if value, err = decodeLockValue(entry.Type, entry.Value); err != nil {
+		return
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	key := lockKey(entry.Func, entry.Args)
+
+	if i, exist := p.indexes[key]; exist {
+		p.entries[i] = entry
+		return
+	}
+
+	p.indexes[key] = len(p.entries)
+	p.entries = append(p.entries, entry)
+
+	return
+}
+
+func (p *Lockfile) Replay(funcName string, args []interface{}) (ret interface{}, err error) {
+	var hash string
+	if hash, err = lockArgs(args); err != nil {
+		return
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if err = p.load(); err != nil {
+		return
+	}
+
+	key := lockKey(funcName, hash)
+
+	entries := p.replays[key]
+	if len(entries) == 0 {
+		err = fmt.Errorf("lockfile %s has no entry of the call of func %s", p.path, funcName)
+		return
+	}
+
+	// the last entry is repeated once the recorded calls are used up
+	cursor := p.cursors[key]
+	if cursor >= len(entries) {
+		cursor = len(entries) - 1
+	}
+	p.cursors[key] = cursor + 1
+
+	entry := entries[cursor]
+
+	if entry.Error != "" {
+		err = errors.New(entry.Error)
+		return
+	}
+
+	return decodeLockValue(entry.Type, entry.Value)
+}
+
+// Flush writes the recorded entries to the lockfile
+func (p *Lockfile) Flush() (err error) {
+	if p.mode != LOCKFILE_RECORD {
+		return
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	var data []byte
+	if data, err = json.MarshalIndent(p.entries, "", "    "); err != nil {
+		return
+	}
+
+	// the recorded values may be secrets, so only the owner could read them
+	return writeFileAtomic(p.path, data, 0600)
+}
+
+func (p *Lockfile) load() (err error) {
+	if p.loaded {
+		return p.loadErr
+	}
+
+	p.loaded = true
+
+	defer func() {
+		p.loadErr = err
+	}()
+
+	var data []byte
+	if data, err = ioutil.ReadFile(p.path); err != nil {
+		return
+	}
+
+	var entries []lockEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		err = fmt.Errorf("decode lockfile %s failure: %s", p.path, err.Error())
+		return
+	}
+
+	for _, entry := range entries {
+		key := lockKey(entry.Func, entry.Args)
+		p.replays[key] = append(p.replays[key], entry)
+	}
+
+	return
+}
+
+// lockArgs returns the sha256 of the args marshaled as json
+func lockArgs(args []interface{}) (hash string, err error) {
+	var data []byte
+	if data, err = json.Marshal(args); err != nil {
+		return
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func lockKey(funcName, argsHash string) string {
+	return funcName + "\x00" + argsHash
+}
+
+func encodeLockValue(v interface{}) (typ string, value json.RawMessage, err error) {
+	switch val := v.(type) {
+	case string:
+		typ = "string"
+	case int:
+		typ = "int"
+	case time.Time:
+		typ = "time"
+		v = val.Format(time.RFC3339Nano)
+	default:
+		typ = "json"
+	}
+
+	value, err = json.Marshal(v)
+
+	return
+}
+
+func decodeLockValue(typ string, value json.RawMessage) (ret interface{}, err error) {
+	switch typ {
+	case "string":
+		{
+			var str string
+			err = json.Unmarshal(value, &str)
+			ret = str
+		}
+	case "int":
+		{
+			ret, err = strconv.Atoi(string(value))
+		}
+	case "time":
+		{
+			var str string
+			if err = json.Unmarshal(value, &str); err != nil {
+				return
+			}
+			ret, err = time.Parse(time.RFC3339Nano, str)
+		}
+	default:
+		{
+			err = json.Unmarshal(value, &ret)
+		}
+	}
+
+	return
+}
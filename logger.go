@@ -0,0 +1,55 @@
+package env_strings
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+const (
+	ENV_STRINGS_DEBUG_KEY = "ENV_STRINGS_DEBUG"
+)
+
+// levelHandler overrides the level of the wrapped handler, so the level is only
+// set by ENV_STRINGS_DEBUG
+type levelHandler struct {
+	level   slog.Leveler
+	handler slog.Handler
+}
+
+func (p *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= p.level.Level()
+}
+
+func (p *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return p.handler.Handle(ctx, record)
+}
+
+func (p *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: p.level, handler: p.handler.WithAttrs(attrs)}
+}
+
+func (p *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: p.level, handler: p.handler.WithGroup(name)}
+}
+
+func isDebug() bool {
+	return os.Getenv(ENV_STRINGS_DEBUG_KEY) == "true"
+}
+
+// newLogger logs to the handler at info level, or at debug level while
+// ENV_STRINGS_DEBUG=true, without a handler the warnings are logged to stderr,
+// such as the stale values, or the debug output while ENV_STRINGS_DEBUG=true
+func newLogger(handler slog.Handler) *slog.Logger {
+	level := slog.LevelInfo
+	if handler == nil {
+		handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		level = slog.LevelWarn
+	}
+
+	if isDebug() {
+		level = slog.LevelDebug
+	}
+
+	return slog.New(&levelHandler{level: level, handler: handler}).With("component", "env_strings")
+}
@@ -0,0 +1,177 @@
+package env_strings
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	CACHE_DEFAULT_MAX_ENTRIES = 10000
+	CACHE_SWEEP_INTERVAL      = time.Minute
+)
+
+type CacheConfig struct {
+	Funcs      map[string]string `json:"funcs"`
+	Storages   map[string]string `json:"storages"`
+	MaxEntries int               `json:"max_entries"`
+}
+
+type cacheEntry struct {
+	ret      interface{}
+	expireAt time.Time
+}
+
+// funcCache keeps the results of the calls by the func name and the args, a
+// zero expire time never expires. The expired entries are swept every sweep
+// interval, and beyond max entries the ones expiring first are evicted, a zero
+// max entries is unlimited
+type funcCache struct {
+	locker     sync.Mutex
+	entries    map[string]cacheEntry
+	maxEntries int
+	lastSweep  time.Time
+}
+
+func newFuncCache(maxEntries int) *funcCache {
+	return &funcCache{
+		entries:    make(map[string]cacheEntry),
+		maxEntries: maxEntries,
+		lastSweep:  time.Now(),
+	}
+}
+
+func (p *funcCache) get(key string) (ret interface{}, exist bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	entry, exist := p.entries[key]
+	if !exist {
+		return
+	}
+
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		delete(p.entries, key)
+		exist = false
+		return
+	}
+
+	ret = entry.ret
+
+	return
+}
+
+func (p *funcCache) put(key string, ret interface{}, ttl time.Duration) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	now := time.Now()
+
+	entry := cacheEntry{ret: ret}
+	if ttl > 0 {
+		entry.expireAt = now.Add(ttl)
+	}
+
+	if now.Sub(p.lastSweep) >= CACHE_SWEEP_INTERVAL {
+		p.sweep(now)
+	}
+
+	if _, exist := p.entries[key]; !exist && p.maxEntries > 0 && len(p.entries) >= p.maxEntries {
+		p.sweep(now)
+		for len(p.entries) >= p.maxEntries {
+			p.evict()
+		}
+	}
+
+	p.entries[key] = entry
+}
+
+func (p *funcCache) sweep(now time.Time) {
+	for key, entry := range p.entries {
+		if !entry.expireAt.IsZero() && now.After(entry.expireAt) {
+			delete(p.entries, key)
+		}
+	}
+	p.lastSweep = now
+}
+
+// evict removes the entry expiring first, the entries never expiring last
+func (p *funcCache) evict() {
+	var evictKey string
+	var evictAt time.Time
+
+	for key, entry := range p.entries {
+		if evictKey == "" || (!entry.expireAt.IsZero() && (evictAt.IsZero() || entry.expireAt.Before(evictAt))) {
+			evictKey, evictAt = key, entry.expireAt
+		}
+	}
+
+	delete(p.entries, evictKey)
+}
+
+// callKey returns the key of the call, the calls whose args could not be
+// marshaled are not cached
+func callKey(funcName string, args []interface{}) (key string, ok bool) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return
+	}
+
+	return funcName + "\x00" + string(data), true
+}
+
+func parseCacheTTLs(ttls map[string]string) map[string]time.Duration {
+	durations := make(map[string]time.Duration, len(ttls))
+	for name, ttl := range ttls {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			panic("env_strings: ttl of cache " + name + " must be duration: " + err.Error())
+		}
+		durations[name] = duration
+	}
+	return durations
+}
+
+type renderScopeKey struct{}
+
+// renderScope keeps the values which must be read once per render, or per
+// batch, such as the generation of a storage
+type renderScope struct {
+	locker sync.Mutex
+	values map[interface{}]interface{}
+}
+
+// withRenderScope returns the ctx with a render scope, the scope of the ctx
+// is kept if it has one
+func withRenderScope(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(renderScopeKey{}).(*renderScope); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, renderScopeKey{}, &renderScope{values: make(map[interface{}]interface{})})
+}
+
+type scopeResult struct {
+	value interface{}
+	err   error
+}
+
+// scopeValue returns the value of the key in the render scope of the ctx, it
+// is loaded once per scope, a failure too, or on every call without a scope
+func scopeValue(ctx context.Context, key interface{}, load func() (interface{}, error)) (value interface{}, err error) {
+	scope, ok := ctx.Value(renderScopeKey{}).(*renderScope)
+	if !ok {
+		return load()
+	}
+
+	scope.locker.Lock()
+	defer scope.locker.Unlock()
+
+	result, ok := scope.values[key].(scopeResult)
+	if !ok {
+		result.value, result.err = load()
+		scope.values[key] = result
+	}
+
+	return result.value, result.err
+}
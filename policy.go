@@ -0,0 +1,315 @@
+package env_strings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	POLICY_DEFAULT_BACKOFF       = 100 * time.Millisecond
+	POLICY_DEFAULT_MAX_BACKOFF   = 5 * time.Second
+	POLICY_DEFAULT_BREAKER_RESET = 30 * time.Second
+)
+
+const (
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half_open"
+)
+
+var ErrCircuitOpen = errors.New("circuit open")
+
+type PolicyConfig struct {
+	Timeout    string         `json:"timeout"`
+	Retries    int            `json:"retries"`
+	Backoff    string         `json:"backoff"`
+	MaxBackoff string         `json:"max_backoff"`
+	Breaker    *BreakerConfig `json:"breaker,omitempty"`
+}
+
+// BreakerConfig opens the circuit after the consecutive failures, once it has
+// been open for reset one call is let through as a probe, which closes it on
+// success or opens it again on failure
+type BreakerConfig struct {
+	Failures int    `json:"failures"`
+	Reset    string `json:"reset"`
+}
+
+type PoliciesConfig struct {
+	Funcs    map[string]PolicyConfig `json:"funcs"`
+	Storages map[string]PolicyConfig `json:"storages"`
+}
+
+// CallPolicy bounds the calls of funcs by a timeout, retries the transient
+// failures with jittered exponential backoff, and breaks the circuit while
+// they keep failing. The funcs of a storage share one policy, so one breaker
+type CallPolicy struct {
+	timeout    time.Duration
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	breaker    *circuitBreaker
+}
+
+func NewCallPolicy(conf PolicyConfig) *CallPolicy {
+	policy := &CallPolicy{
+		retries:    conf.Retries,
+		backoff:    POLICY_DEFAULT_BACKOFF,
+		maxBackoff: POLICY_DEFAULT_MAX_BACKOFF,
+	}
+
+	policy.timeout = parsePolicyDuration("timeout", conf.Timeout, 0)
+	policy.backoff = parsePolicyDuration("backoff", conf.Backoff, policy.backoff)
+	policy.maxBackoff = parsePolicyDuration("max_backoff", conf.MaxBackoff, policy.maxBackoff)
+
+	if conf.Breaker != nil && conf.Breaker.Failures > 0 {
+		policy.breaker = &circuitBreaker{
+			failures: conf.Breaker.Failures,
+			reset:    parsePolicyDuration("reset", conf.Breaker.Reset, POLICY_DEFAULT_BREAKER_RESET),
+			state:    BREAKER_CLOSED,
+		}
+	}
+
+	return policy
+}
+
+func parsePolicyDuration(name, value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic("option of " + name + " must be duration: " + err.Error())
+	}
+
+	return duration
+}
+
+// State is the state of the breaker, BREAKER_CLOSED if there is none
+func (p *CallPolicy) State() string {
+	if p.breaker == nil {
+		return BREAKER_CLOSED
+	}
+	return p.breaker.current()
+}
+
+func (p *CallPolicy) call(ctx context.Context, fn interface{}, args []interface{}) (interface{}, error) {
+	return p.run(ctx, takesContext(fn), func(ctx context.Context) (interface{}, error) {
+		return callContext(ctx, fn, args...)
+	})
+}
+
+// prefetch runs the prefetch of the storage by the policy
+func (p *CallPolicy) prefetch(ctx context.Context, prefetcher ExtFuncsPrefetcher, calls []FuncCall) (funcs template.FuncMap, err error) {
+	var ret interface{}
+	if ret, err = p.run(ctx, false, func(ctx context.Context) (interface{}, error) {
+		return prefetcher.Prefetch(ctx, calls)
+	}); err != nil {
+		return
+	}
+
+	funcs, _ = ret.(template.FuncMap)
+
+	return
+}
+
+// run calls the fn by the timeout, the retries and the breaker. While the
+// circuit is open, the fn taking a context is called with a ctx already past
+// its deadline if fallback is set, so it could fall back to its stale or
+// default values as if the backend were unreachable
+func (p *CallPolicy) run(ctx context.Context, fallback bool, fn func(ctx context.Context) (interface{}, error)) (ret interface{}, err error) {
+	if p.breaker != nil {
+		if err = p.breaker.allow(); err != nil {
+			if fallback {
+				expired, cancel := context.WithDeadline(ctx, time.Now())
+				defer cancel()
+
+				if r, e := fn(expired); e == nil && r != nil {
+					ret, err = r, nil
+				}
+			}
+			return
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		ret, err = p.callOnce(ctx, fn)
+		if !failure(ret, err) || attempt >= p.retries || ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			{
+				err = ctx.Err()
+			}
+		case <-time.After(p.backoffOf(attempt)):
+			{
+				continue
+			}
+		}
+
+		break
+	}
+
+	if p.breaker != nil {
+		p.breaker.done(!failure(ret, err))
+	}
+
+	return
+}
+
+// callOnce calls the fn with the timeout, the fn is cancelled by the ctx if it
+// takes it, or left running in the background
+func (p *CallPolicy) callOnce(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (ret interface{}, err error) {
+	if p.timeout <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	type result struct {
+		ret interface{}
+		err error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		ret, err := fn(ctx)
+		done <- result{ret, err}
+	}()
+
+	select {
+	case r := <-done:
+		{
+			return r.ret, r.err
+		}
+	case <-ctx.Done():
+		{
+			// the fn may have fallen back on the deadline
+			select {
+			case r := <-done:
+				return r.ret, r.err
+			default:
+			}
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("call timed out after %s: %w", p.timeout, ctx.Err())
+				return
+			}
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// backoffOf is the exponential backoff of the attempt with full jitter over its
+// upper half
+func (p *CallPolicy) backoffOf(attempt int) time.Duration {
+	backoff := p.maxBackoff
+	if attempt < 32 && p.backoff<<uint(attempt) < p.maxBackoff {
+		backoff = p.backoff << uint(attempt)
+	}
+
+	if backoff <= 1 {
+		return backoff
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+// failure reports whether the call failed for the retries and the breaker, a
+// stale value is a failure of the backend served by the fallback of the func
+func failure(ret interface{}, err error) bool {
+	if err != nil {
+		return transient(err)
+	}
+	_, stale := ret.(StaleValue)
+	return stale
+}
+
+// transient reports whether the failure may pass on a retry, the missing keys
+// and the errors answered by the backends are not
+func transient(err error) bool {
+	if errors.Is(err, ErrMissingKey) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var backendErr *BackendError
+	if errors.As(err, &backendErr) && !backendErr.Unavailable {
+		return false
+	}
+
+	return true
+}
+
+type circuitBreaker struct {
+	locker   sync.Mutex
+	failures int
+	reset    time.Duration
+
+	state    string
+	failed   int
+	openedAt time.Time
+	probing  bool
+}
+
+func (p *circuitBreaker) current() string {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.state
+}
+
+func (p *circuitBreaker) allow() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	switch p.state {
+	case BREAKER_OPEN:
+		{
+			if wait := p.reset - time.Since(p.openedAt); wait > 0 {
+				return fmt.Errorf("%w, retry after %s", ErrCircuitOpen, wait.Round(time.Millisecond))
+			}
+			p.state = BREAKER_HALF_OPEN
+			p.probing = true
+		}
+	case BREAKER_HALF_OPEN:
+		{
+			if p.probing {
+				return fmt.Errorf("%w, probing", ErrCircuitOpen)
+			}
+			p.probing = true
+		}
+	}
+
+	return nil
+}
+
+func (p *circuitBreaker) done(success bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if success {
+		p.state = BREAKER_CLOSED
+		p.failed = 0
+		p.probing = false
+		return
+	}
+
+	p.failed++
+
+	if p.state == BREAKER_HALF_OPEN || p.failed >= p.failures {
+		p.state = BREAKER_OPEN
+		p.openedAt = time.Now()
+		p.probing = false
+	}
+}
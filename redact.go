@@ -0,0 +1,319 @@
+package env_strings
+
+import (
+	"container/list"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	REDACTED = "******"
+
+	REDACT_DEFAULT_MAX_SECRETS = 1000
+
+	ENV_STRINGS_DEBUG_UNSAFE_KEY = "ENV_STRINGS_DEBUG_UNSAFE"
+
+	// the secret values shorter than this are not replaced in strings, or
+	// every "1" and "on" in the output would be masked
+	redactMinLength = 4
+)
+
+var (
+	DefaultRedactKeys = []string{"*password*", "*passwd*", "*secret*", "*token*", "*credential*", "*private_key*", "*authorization*"}
+)
+
+type RedactConfig struct {
+	Keys       []string `json:"keys"`
+	Funcs      []string `json:"funcs"`
+	Unsafe     bool     `json:"unsafe"`
+	MaxSecrets int      `json:"max_secrets"`
+}
+
+// Redactor masks the secrets in debug output, usage statistics and errors, a
+// secret is the value of a key matching one of the key patterns, or the result
+// of a secret func, or of a call with an arg matching one of the key patterns.
+// The secrets seen least recently are forgotten beyond max secrets, so the
+// rotated ones do not pile up in a long running process
+type Redactor struct {
+	keys       []string
+	unsafe     bool
+	maxSecrets int
+
+	locker  sync.RWMutex
+	funcs   map[string]bool
+	secrets map[string]*list.Element
+	recent  *list.List
+	sorted  []string
+}
+
+func NewRedactor(conf RedactConfig) *Redactor {
+	redactor := &Redactor{
+		keys:       conf.Keys,
+		unsafe:     conf.Unsafe,
+		maxSecrets: conf.MaxSecrets,
+		funcs:      make(map[string]bool),
+		secrets:    make(map[string]*list.Element),
+		recent:     list.New(),
+	}
+
+	if redactor.maxSecrets <= 0 {
+		redactor.maxSecrets = REDACT_DEFAULT_MAX_SECRETS
+	}
+
+	if len(redactor.keys) == 0 {
+		redactor.keys = DefaultRedactKeys
+	}
+
+	redactor.SecretFuncs(conf.Funcs...)
+
+	return redactor
+}
+
+func (p *Redactor) Unsafe() bool {
+	return p.unsafe
+}
+
+func (p *Redactor) SecretFuncs(names ...string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	for _, name := range names {
+		p.funcs[name] = true
+	}
+}
+
+func (p *Redactor) IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range p.keys {
+		if matched, _ := path.Match(strings.ToLower(pattern), key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSecretCall reports whether the result of the call is a secret
+func (p *Redactor) IsSecretCall(funcName string, args []interface{}) bool {
+	p.locker.RLock()
+	secret := p.funcs[funcName]
+	p.locker.RUnlock()
+
+	if secret {
+		return true
+	}
+
+	for _, arg := range args {
+		if str, ok := arg.(string); ok && p.IsSecretKey(str) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Redactor) AddSecret(value interface{}) {
+	str, ok := value.(string)
+	if !ok || str == "" {
+		return
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if elem, exist := p.secrets[str]; exist {
+		p.recent.MoveToFront(elem)
+		return
+	}
+
+	p.secrets[str] = p.recent.PushFront(str)
+
+	if len(str) >= redactMinLength {
+		p.sorted = append(p.sorted, str)
+		// the longer secrets are replaced first, in case one contains another
+		sort.Slice(p.sorted, func(i, j int) bool {
+			return len(p.sorted[i]) > len(p.sorted[j])
+		})
+	}
+
+	for p.recent.Len() > p.maxSecrets {
+		p.forget(p.recent.Remove(p.recent.Back()).(string))
+	}
+}
+
+func (p *Redactor) forget(str string) {
+	delete(p.secrets, str)
+
+	for i, secret := range p.sorted {
+		if secret == str {
+			p.sorted = append(p.sorted[:i], p.sorted[i+1:]...)
+			break
+		}
+	}
+}
+
+// Tree returns a copy of the env tree with the values of the secret keys
+// masked, the masked values are remembered as secrets
+func (p *Redactor) Tree(tree interface{}) interface{} {
+	return p.redactTree(tree, false)
+}
+
+// TreeSecrets remembers the values of the secret keys of the env tree as
+// secrets, as Tree does without copying the tree
+func (p *Redactor) TreeSecrets(tree interface{}) {
+	p.treeSecrets(tree, false)
+}
+
+func (p *Redactor) treeSecrets(v interface{}, secret bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		{
+			for k, child := range val {
+				p.treeSecrets(child, secret || p.IsSecretKey(k))
+			}
+		}
+	case []interface{}:
+		{
+			for _, child := range val {
+				p.treeSecrets(child, secret)
+			}
+		}
+	default:
+		{
+			if secret {
+				p.AddSecret(v)
+			}
+		}
+	}
+}
+
+func (p *Redactor) redactTree(v interface{}, secret bool) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		{
+			ret := make(map[string]interface{}, len(val))
+			for k, child := range val {
+				ret[k] = p.redactTree(child, secret || p.IsSecretKey(k))
+			}
+			return ret
+		}
+	case []interface{}:
+		{
+			ret := make([]interface{}, len(val))
+			for i, child := range val {
+				ret[i] = p.redactTree(child, secret)
+			}
+			return ret
+		}
+	}
+
+	if !secret {
+		return p.Value(v)
+	}
+
+	p.AddSecret(v)
+
+	if p.unsafe {
+		return v
+	}
+
+	return REDACTED
+}
+
+// Value masks the value if it is a known secret
+func (p *Redactor) Value(v interface{}) interface{} {
+	if p.unsafe {
+		return v
+	}
+
+	if header, ok := v.(HTTPHeader); ok {
+		if p.IsSecretKey(header.Name) {
+			header.Value = REDACTED
+		}
+		return header
+	}
+
+	str, ok := v.(string)
+	if !ok || str == "" {
+		return v
+	}
+
+	p.locker.RLock()
+	_, secret := p.secrets[str]
+	p.locker.RUnlock()
+
+	if secret {
+		return REDACTED
+	}
+
+	return p.String(str)
+}
+
+// String replaces the known secrets in the string
+func (p *Redactor) String(str string) string {
+	if p.unsafe {
+		return str
+	}
+
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	for _, secret := range p.sorted {
+		str = strings.Replace(str, secret, REDACTED, -1)
+	}
+
+	return str
+}
+
+func (p *Redactor) Error(err error) error {
+	if err == nil || p.unsafe {
+		return err
+	}
+
+	msg := p.String(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+
+	return &redactedError{msg: msg, err: err}
+}
+
+func (p *Redactor) Item(item FuncStaticItem) FuncStaticItem {
+	if p.unsafe {
+		return item
+	}
+
+	input := make([]interface{}, len(item.Input))
+	for i, v := range item.Input {
+		input[i] = p.Value(v)
+	}
+
+	output := make([]interface{}, len(item.Output))
+	for i, v := range item.Output {
+		if err, ok := v.(error); ok {
+			output[i] = p.Error(err)
+		} else {
+			output[i] = p.Value(v)
+		}
+	}
+
+	item.Input = input
+	item.Output = output
+
+	return item
+}
+
+// redactedError keeps the original error for errors.Is and errors.As
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (p *redactedError) Error() string {
+	return p.msg
+}
+
+func (p *redactedError) Unwrap() error {
+	return p.err
+}
@@ -0,0 +1,299 @@
+package env_strings
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoisie/redis"
+)
+
+const (
+	REDIS_DEFAULT_ADDR         = "127.0.0.1:6379"
+	REDIS_DEFAULT_SCAN_COUNT   = 1000
+	REDIS_DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+)
+
+// RedisConn is a connection to redis pipelining the commands, which the
+// client does not support. The connection is closed once the ctx is done
+type RedisConn struct {
+	ctx  context.Context
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// DialRedis connects to the redis of the client within the dial timeout,
+// authenticates by its password and selects its db
+func DialRedis(ctx context.Context, client redis.Client) (conn *RedisConn, err error) {
+	addr := client.Addr
+	if addr == "" {
+		addr = REDIS_DEFAULT_ADDR
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+
+	dialer := net.Dialer{Timeout: REDIS_DEFAULT_DIAL_TIMEOUT}
+
+	var c net.Conn
+	if c, err = dialer.DialContext(ctx, network, addr); err != nil {
+		return
+	}
+
+	conn = &RedisConn{
+		ctx:  ctx,
+		conn: c,
+		r:    bufio.NewReader(c),
+		w:    bufio.NewWriter(c),
+		stop: make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-conn.stop:
+		}
+	}()
+
+	var cmds [][]string
+	if client.Password != "" {
+		cmds = append(cmds, []string{"AUTH", client.Password})
+	}
+	if client.Db != 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(client.Db)})
+	}
+
+	if len(cmds) > 0 {
+		if _, err = conn.Pipeline(cmds...); err != nil {
+			conn.Close()
+			conn = nil
+			return
+		}
+	}
+
+	return
+}
+
+func (p *RedisConn) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+	return p.conn.Close()
+}
+
+// Pipeline sends the commands in one round trip and returns their replies, a
+// reply is a string, an int64, a []byte, nil or an []interface{} of them. The
+// first error replied is returned as redis.RedisError
+func (p *RedisConn) Pipeline(cmds ...[]string) (replies []interface{}, err error) {
+	defer func() {
+		if err != nil && p.ctx.Err() != nil {
+			err = p.ctx.Err()
+		}
+	}()
+
+	if err = p.send(cmds...); err != nil {
+		return
+	}
+
+	var replyErr error
+
+	replies = make([]interface{}, len(cmds))
+	for i := range cmds {
+		if replies[i], err = p.readReply(); err != nil {
+			var redisErr redis.RedisError
+			if !errors.As(err, &redisErr) {
+				replies = nil
+				return
+			}
+			if replyErr == nil {
+				replyErr = err
+			}
+			err = nil
+		}
+	}
+
+	if replyErr != nil {
+		replies = nil
+		err = replyErr
+	}
+
+	return
+}
+
+// Subscribe subscribes the channel, or the channels matching it as a pattern,
+// and passes the channel of every message to fn until the connection fails or
+// is closed by the ctx
+func (p *RedisConn) Subscribe(channel string, pattern bool, fn func(channel string)) (err error) {
+	defer func() {
+		if p.ctx.Err() != nil {
+			err = p.ctx.Err()
+		}
+	}()
+
+	cmd := "SUBSCRIBE"
+	if pattern {
+		cmd = "PSUBSCRIBE"
+	}
+
+	if err = p.send([]string{cmd, channel}); err != nil {
+		return
+	}
+
+	for {
+		var reply interface{}
+		if reply, err = p.readReply(); err != nil {
+			return
+		}
+
+		items, _ := reply.([]interface{})
+		if len(items) < 3 {
+			continue
+		}
+
+		kind, _ := items[0].([]byte)
+
+		switch string(kind) {
+		case "message":
+			{
+				ch, _ := items[1].([]byte)
+				fn(string(ch))
+			}
+		case "pmessage":
+			{
+				if len(items) < 4 {
+					continue
+				}
+				ch, _ := items[2].([]byte)
+				fn(string(ch))
+			}
+		}
+	}
+}
+
+// Scan iterates the keys matching the pattern by SCAN, the keys are passed to
+// fn by batches
+func (p *RedisConn) Scan(pattern string, fn func(keys []string) error) (err error) {
+	cursor := "0"
+
+	for {
+		var replies []interface{}
+		if replies, err = p.Pipeline([]string{"SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(REDIS_DEFAULT_SCAN_COUNT)}); err != nil {
+			return
+		}
+
+		reply, ok := replies[0].([]interface{})
+		if !ok || len(reply) != 2 {
+			err = errors.New("unexpected reply of SCAN")
+			return
+		}
+
+		next, _ := reply[0].([]byte)
+		items, _ := reply[1].([]interface{})
+
+		keys := make([]string, 0, len(items))
+		for _, item := range items {
+			if key, ok := item.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+
+		if len(keys) > 0 {
+			if err = fn(keys); err != nil {
+				return
+			}
+		}
+
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+func (p *RedisConn) send(cmds ...[]string) (err error) {
+	for _, cmd := range cmds {
+		fmt.Fprintf(p.w, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			fmt.Fprintf(p.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+
+	return p.w.Flush()
+}
+
+func (p *RedisConn) readReply() (reply interface{}, err error) {
+	var line string
+	if line, err = p.r.ReadString('\n'); err != nil {
+		return
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		err = errors.New("empty reply of redis")
+		return
+	}
+
+	switch line[0] {
+	case '+':
+		{
+			reply = line[1:]
+		}
+	case '-':
+		{
+			err = redis.RedisError(line[1:])
+		}
+	case ':':
+		{
+			reply, err = strconv.ParseInt(line[1:], 10, 64)
+		}
+	case '$':
+		{
+			var size int
+			if size, err = strconv.Atoi(line[1:]); err != nil || size < 0 {
+				return
+			}
+
+			data := make([]byte, size+2)
+			if _, err = io.ReadFull(p.r, data); err != nil {
+				return
+			}
+
+			reply = data[:size]
+		}
+	case '*':
+		{
+			var size int
+			if size, err = strconv.Atoi(line[1:]); err != nil || size < 0 {
+				return
+			}
+
+			items := make([]interface{}, size)
+			for i := range items {
+				if items[i], err = p.readReply(); err != nil {
+					return
+				}
+			}
+
+			reply = items
+		}
+	default:
+		{
+			err = fmt.Errorf("unexpected reply of redis: %q", line)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,261 @@
+package env_strings
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	RENDER_TEMPLATE_EXT       = ".tmpl"
+	RENDER_RELOAD_PENDING_EXT = ".reload-pending"
+)
+
+// RenderResult is a template rendered to an output file, Changed is false if
+// the output had the same content and was not written, Reloaded is true if
+// the reload command ran, Error is the failure of the template
+type RenderResult struct {
+	Template string `json:"template"`
+	Output   string `json:"output"`
+	Changed  bool   `json:"changed"`
+	Reloaded bool   `json:"reloaded"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RenderDir renders every *.tmpl file under the src dir to the same path under
+// the dest dir without the .tmpl extension, with the mode and the owner of the
+// template. The outputs are written atomically by temp file and rename, and
+// skipped if their content is not changed. The env tree is loaded once for all
+// the templates, a failed template does not stop the others, the errors of all
+// the templates are returned joined
+func (p *EnvStrings) RenderDir(srcDir, destDir string) (results []RenderResult, err error) {
+	return p.RenderDirContext(context.Background(), srcDir, destDir)
+}
+
+func (p *EnvStrings) RenderDirContext(ctx context.Context, srcDir, destDir string) (results []RenderResult, err error) {
+	srcDir = filepath.Clean(srcDir)
+
+	var tree *envTree
+	if tree, err = p.loadTree(nil); err != nil {
+		err = p.tmplFuncs.Redactor().Error(err)
+		return
+	}
+
+	var errs []error
+
+	if err = filepath.Walk(srcDir, func(path string, info os.FileInfo, e error) (err error) {
+		if e != nil {
+			return e
+		}
+
+		if info.IsDir() || !strings.HasSuffix(info.Name(), RENDER_TEMPLATE_EXT) {
+			return
+		}
+
+		var rel string
+		if rel, err = filepath.Rel(srcDir, path); err != nil {
+			return
+		}
+
+		output := filepath.Join(destDir, strings.TrimSuffix(rel, RENDER_TEMPLATE_EXT))
+
+		result := RenderResult{Template: path, Output: output}
+
+		if e = mkdirAllAs(filepath.Dir(output), filepath.Dir(path), srcDir); e == nil {
+			r := newRender(ctx, p.envName)
+			r.tree = tree
+
+			result, e = p.renderFile(r, TemplateConfig{Src: path, Dest: output})
+		}
+
+		if e != nil {
+			e = p.tmplFuncs.Redactor().Error(e)
+			p.logger.Warn("render template failure", "env", p.envName, "template", path, "error", e)
+			errs = append(errs, e)
+			result.Error = e.Error()
+		}
+
+		results = append(results, result)
+
+		return
+	}); err != nil {
+		errs = append(errs, err)
+	}
+
+	err = errors.Join(errs...)
+
+	return
+}
+
+// renderFile renders the template to the output with the mode and the owner of
+// the template, the output is not written if its content is not changed, but
+// its mode and owner are still set to the template's. The
+// staged output must pass the check command before it replaces the output,
+// and the reload command is run after. A marker beside the output is kept
+// until the reload succeeds, so a failed reload is retried by the next render
+// even if the output is not changed
+func (p *EnvStrings) renderFile(r *render, conf TemplateConfig) (result RenderResult, err error) {
+	result = RenderResult{Template: conf.Src, Output: conf.Dest}
+
+	var info os.FileInfo
+	if info, err = os.Stat(conf.Src); err != nil {
+		return
+	}
+
+	var data []byte
+	if data, err = ioutil.ReadFile(conf.Src); err != nil {
+		return
+	}
+
+	r.name = conf.Src
+
+	var buf bytes.Buffer
+	if err = p.execute(r, string(data), nil, &buf); err != nil {
+		return
+	}
+
+	marker := reloadMarker(conf.Dest)
+
+	old, e := ioutil.ReadFile(conf.Dest)
+	if e == nil && sha256.Sum256(old) == sha256.Sum256(buf.Bytes()) {
+		p.logger.Debug("output unchanged", "env", p.envName, "file", conf.Dest)
+
+		if err = chmodAs(conf.Dest, info); err != nil {
+			return
+		}
+
+		if err = chownAs(conf.Dest, info); err != nil {
+			return
+		}
+
+		if _, e = os.Stat(marker); e == nil && conf.ReloadCmd != "" {
+			p.logger.Info("retry pending reload", "env", p.envName, "file", conf.Dest)
+			result.Reloaded, err = p.reload(r, conf, marker)
+		}
+
+		return
+	}
+
+	var staged string
+	if staged, err = stageFile(conf.Dest, buf.Bytes(), info.Mode().Perm(), info); err != nil {
+		return
+	}
+
+	if conf.CheckCmd != "" {
+		var output string
+		if output, err = runTemplateCmd(r.ctx, conf.CheckCmd, staged, conf.Dest); err != nil {
+			os.Remove(staged)
+			err = &CommandError{
+				Stage:    "check",
+				Template: conf.Src,
+				Cmd:      conf.CheckCmd,
+				Output:   output,
+				Diff:     p.tmplFuncs.Redactor().String(unifiedDiff(conf.Dest, conf.Dest+" (rendered)", string(old), buf.String())),
+				Err:      err,
+			}
+			return
+		}
+	}
+
+	if conf.ReloadCmd != "" {
+		if err = ioutil.WriteFile(marker, nil, 0600); err != nil {
+			os.Remove(staged)
+			return
+		}
+	}
+
+	if err = os.Rename(staged, conf.Dest); err != nil {
+		os.Remove(staged)
+		return
+	}
+
+	result.Changed = true
+
+	p.logger.Info("output rendered", "env", p.envName, "file", conf.Dest, "template", conf.Src)
+
+	if conf.ReloadCmd != "" {
+		result.Reloaded, err = p.reload(r, conf, marker)
+	}
+
+	return
+}
+
+// reload runs the reload command of the template, and removes the pending
+// marker once it succeeded
+func (p *EnvStrings) reload(r *render, conf TemplateConfig, marker string) (reloaded bool, err error) {
+	var output string
+	if output, err = runTemplateCmd(r.ctx, conf.ReloadCmd, conf.Dest, conf.Dest); err != nil {
+		err = &CommandError{Stage: "reload", Template: conf.Src, Cmd: conf.ReloadCmd, Output: output, Err: err}
+		return
+	}
+
+	reloaded = true
+
+	if e := os.Remove(marker); e != nil && !os.IsNotExist(e) {
+		p.logger.Warn("remove reload marker failure", "env", p.envName, "file", marker, "error", e)
+	}
+
+	return
+}
+
+// reloadMarker is the hidden file beside the output marking its reload pending
+func reloadMarker(dest string) string {
+	return filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+RENDER_RELOAD_PENDING_EXT)
+}
+
+// runTemplateCmd runs the command by the shell, the {{.src}} of the command is
+// the staged file and the {{.dest}} is the output file
+func runTemplateCmd(ctx context.Context, cmd, src, dest string) (output string, err error) {
+	var out []byte
+	out, err = shellCommand(ctx, cmd, src, dest).CombinedOutput()
+	output = string(out)
+
+	return
+}
+
+// chmodAs changes the mode of the file to the mode of the file info, if they
+// differ
+func chmodAs(fileName string, info os.FileInfo) (err error) {
+	var current os.FileInfo
+	if current, err = os.Stat(fileName); err != nil {
+		return
+	}
+
+	if current.Mode().Perm() == info.Mode().Perm() {
+		return
+	}
+
+	return os.Chmod(fileName, info.Mode().Perm())
+}
+
+// mkdirAllAs creates the dir and its parents with the mode and the owner of
+// the source dirs matching them, up to the root of the sources
+func mkdirAllAs(dir, srcDir, srcRoot string) (err error) {
+	if _, err = os.Stat(dir); err == nil || !os.IsNotExist(err) {
+		return
+	}
+
+	if srcDir != srcRoot {
+		if err = mkdirAllAs(filepath.Dir(dir), filepath.Dir(srcDir), srcRoot); err != nil {
+			return
+		}
+	} else if err = os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return
+	}
+
+	var info os.FileInfo
+	if info, err = os.Stat(srcDir); err != nil {
+		return
+	}
+
+	if err = os.Mkdir(dir, info.Mode().Perm()); err != nil {
+		return
+	}
+
+	return chownAs(dir, info)
+}
@@ -0,0 +1,94 @@
+package env_strings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// TemplateConfig is a template of the render manifest. The check command runs
+// with the staged output as {{.src}} before it replaces the dest, such as
+// "nginx -t -c {{.src}}", and the reload command runs after the dest changed
+type TemplateConfig struct {
+	Src       string `json:"src"`
+	Dest      string `json:"dest"`
+	CheckCmd  string `json:"check_cmd,omitempty"`
+	ReloadCmd string `json:"reload_cmd,omitempty"`
+}
+
+type RenderManifest struct {
+	Templates []TemplateConfig `json:"templates"`
+}
+
+// LoadRenderManifest loads the manifest of the json file, the relative paths
+// of the templates are relative to the dir of the manifest
+func LoadRenderManifest(fileName string) (manifest *RenderManifest, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(fileName); err != nil {
+		return
+	}
+
+	manifest = &RenderManifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		err = &DecodeError{File: fileName, Err: err}
+		return
+	}
+
+	dir := filepath.Dir(fileName)
+
+	for i := range manifest.Templates {
+		conf := &manifest.Templates[i]
+		if !filepath.IsAbs(conf.Src) {
+			conf.Src = filepath.Join(dir, conf.Src)
+		}
+		if !filepath.IsAbs(conf.Dest) {
+			conf.Dest = filepath.Join(dir, conf.Dest)
+		}
+	}
+
+	return
+}
+
+// RenderManifest renders the templates of the manifest as RenderDir, the check
+// and the reload commands run for the changed outputs. A failed template does
+// not stop the others, the errors of all the templates are returned joined
+func (p *EnvStrings) RenderManifest(manifest *RenderManifest) (results []RenderResult, err error) {
+	return p.RenderManifestContext(context.Background(), manifest)
+}
+
+func (p *EnvStrings) RenderManifestContext(ctx context.Context, manifest *RenderManifest) (results []RenderResult, err error) {
+	var tree *envTree
+	if tree, err = p.loadTree(nil); err != nil {
+		err = p.tmplFuncs.Redactor().Error(err)
+		return
+	}
+
+	var errs []error
+
+	for _, conf := range manifest.Templates {
+		if e := os.MkdirAll(filepath.Dir(conf.Dest), 0755); e != nil {
+			errs = append(errs, e)
+			continue
+		}
+
+		r := newRender(ctx, p.envName)
+		r.tree = tree
+
+		result, e := p.renderFile(r, conf)
+		if e != nil {
+			e = p.tmplFuncs.Redactor().Error(e)
+			p.logger.Warn("render template failure", "env", p.envName, "template", conf.Src, "error", e)
+			errs = append(errs, e)
+			result.Error = e.Error()
+		}
+
+		results = append(results, result)
+	}
+
+	err = errors.Join(errs...)
+
+	return
+}
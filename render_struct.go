@@ -0,0 +1,168 @@
+package env_strings
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	STRUCT_TAG_KEY      = "env_strings"
+	STRUCT_TAG_SKIP     = "-"
+	STRUCT_TAG_REQUIRED = "required"
+)
+
+// RenderStruct renders every string of the nested structs, maps, slices and
+// arrays of v in place, v must be a pointer. The fields tagged with
+// `env_strings:"-"` are skipped, and the fields tagged with
+// `env_strings:"required"` must not be rendered empty. The env tree is loaded
+// once for all the strings, the errors are *FieldError with the path of the field
+func (p *EnvStrings) RenderStruct(v interface{}) (err error) {
+	return p.RenderStructContext(context.Background(), v)
+}
+
+func (p *EnvStrings) RenderStructContext(ctx context.Context, v interface{}) (err error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		err = fmt.Errorf("render struct of %T failure, it must be a non-nil pointer", v)
+		return
+	}
+
+	r := newRender(ctx, p.envName)
+
+	if r.tree, err = p.loadTree(nil); err != nil {
+		err = p.tmplFuncs.Redactor().Error(err)
+		return
+	}
+
+	walker := &structWalker{envStrings: p, render: r}
+
+	return walker.walk(value.Elem(), "", false)
+}
+
+type structWalker struct {
+	envStrings *EnvStrings
+	render     *render
+}
+
+func (p *structWalker) walk(value reflect.Value, path string, required bool) (err error) {
+	switch value.Kind() {
+	case reflect.String:
+		{
+			var ret string
+			if ret, err = p.renderString(value.String(), path, required); err != nil {
+				return
+			}
+			if value.CanSet() {
+				value.SetString(ret)
+			}
+		}
+	case reflect.Ptr:
+		{
+			if value.IsNil() {
+				if required {
+					err = &FieldError{Path: path, Err: ErrRequired}
+				}
+				return
+			}
+			return p.walk(value.Elem(), path, required)
+		}
+	case reflect.Interface:
+		{
+			if value.IsNil() {
+				if required {
+					err = &FieldError{Path: path, Err: ErrRequired}
+				}
+				return
+			}
+
+			elem := value.Elem()
+			if elem.Kind() != reflect.String {
+				return p.walk(elem, path, required)
+			}
+
+			var ret string
+			if ret, err = p.renderString(elem.String(), path, required); err != nil {
+				return
+			}
+			if value.CanSet() {
+				value.Set(reflect.ValueOf(ret).Convert(elem.Type()))
+			}
+		}
+	case reflect.Struct:
+		{
+			valueType := value.Type()
+			for i := 0; i < value.NumField(); i++ {
+				field := valueType.Field(i)
+				if field.PkgPath != "" {
+					continue
+				}
+
+				tag := field.Tag.Get(STRUCT_TAG_KEY)
+				if tag == STRUCT_TAG_SKIP {
+					continue
+				}
+
+				if err = p.walk(value.Field(i), joinFieldPath(path, field.Name), tag == STRUCT_TAG_REQUIRED); err != nil {
+					return
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		{
+			for i := 0; i < value.Len(); i++ {
+				if err = p.walk(value.Index(i), fmt.Sprintf("%s[%d]", path, i), required); err != nil {
+					return
+				}
+			}
+		}
+	case reflect.Map:
+		{
+			iter := value.MapRange()
+			for iter.Next() {
+				elemPath := fmt.Sprintf("%s[%#v]", path, iter.Key().Interface())
+
+				// the values of a map are not addressable, they are rendered in a copy
+				elem := reflect.New(iter.Value().Type()).Elem()
+				elem.Set(iter.Value())
+
+				if err = p.walk(elem, elemPath, required); err != nil {
+					return
+				}
+
+				value.SetMapIndex(iter.Key(), elem)
+			}
+		}
+	}
+
+	return
+}
+
+func (p *structWalker) renderString(str, path string, required bool) (ret string, err error) {
+	ret = str
+
+	if strings.Contains(str, "{{") {
+		var buf bytes.Buffer
+		if err = p.envStrings.execute(p.render, str, nil, &buf); err != nil {
+			err = &FieldError{Path: path, Err: err}
+			return
+		}
+		ret = buf.String()
+	}
+
+	if required && ret == "" {
+		err = &FieldError{Path: path, Err: ErrRequired}
+		return
+	}
+
+	return
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
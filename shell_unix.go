@@ -0,0 +1,18 @@
+//go:build !windows
+
+package env_strings
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// shellCommand runs the command by sh, the src and the dest are passed to sh as
+// the positional args, so the paths are never parsed by the shell
+func shellCommand(ctx context.Context, cmd, src, dest string) *exec.Cmd {
+	cmd = strings.Replace(cmd, "{{.src}}", `"$1"`, -1)
+	cmd = strings.Replace(cmd, "{{.dest}}", `"$2"`, -1)
+
+	return exec.CommandContext(ctx, "/bin/sh", "-c", cmd, "sh", src, dest)
+}
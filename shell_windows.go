@@ -0,0 +1,25 @@
+//go:build windows
+
+package env_strings
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// shellCommand runs the command by cmd, the src and the dest are passed to cmd
+// as the environment variables, so the paths are never parsed by the shell
+func shellCommand(ctx context.Context, cmd, src, dest string) *exec.Cmd {
+	cmd = strings.Replace(cmd, "{{.src}}", `"%ENV_STRINGS_SRC%"`, -1)
+	cmd = strings.Replace(cmd, "{{.dest}}", `"%ENV_STRINGS_DEST%"`, -1)
+
+	c := exec.CommandContext(ctx, "cmd.exe")
+	// the command line is passed as is, cmd does not parse the quotes of go
+	c.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd.exe /S /C "` + cmd + `"`}
+	c.Env = append(os.Environ(), "ENV_STRINGS_SRC="+src, "ENV_STRINGS_DEST="+dest)
+
+	return c
+}
@@ -0,0 +1,266 @@
+package env_strings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SNAPSHOT_DEFAULT_REFRESH is how often the timestamps of the unchanged
+// values are written without a max staleness
+const SNAPSHOT_DEFAULT_REFRESH = time.Hour
+
+type SnapshotConfig struct {
+	Path         string `json:"path"`
+	MaxStaleness string `json:"max_staleness"`
+}
+
+// StaleValue is returned by ext funcs when the backend is unavailable and the
+// value is served from the last known good snapshot, it will be unwrapped
+// before the value reaches the template
+type StaleValue struct {
+	Value     interface{}
+	UpdatedAt time.Time
+}
+
+func (p StaleValue) String() string {
+	return fmt.Sprint(p.Value)
+}
+
+type snapshotEntry struct {
+	Engine    string    `json:"engine"`
+	Key       string    `json:"key"`
+	Field     string    `json:"field,omitempty"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// the time the value was written to disk
+	written time.Time
+}
+
+// SnapshotCache keeps the last known good value of every successful backend
+// lookup on disk, a zero max staleness means the values never expire
+type SnapshotCache struct {
+	path         string
+	maxStaleness time.Duration
+
+	locker  sync.Mutex
+	loaded  bool
+	dirty   bool
+	entries map[string]snapshotEntry
+}
+
+func NewSnapshotCache(path string, maxStaleness time.Duration) *SnapshotCache {
+	return &SnapshotCache{
+		path:         path,
+		maxStaleness: maxStaleness,
+		entries:      make(map[string]snapshotEntry),
+	}
+}
+
+func snapshotKey(engine, key, field string) string {
+	return engine + "\x00" + key + "\x00" + field
+}
+
+func (p *SnapshotCache) Put(engine, key, field, value string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.load()
+
+	k := snapshotKey(engine, key, field)
+
+	now := time.Now()
+
+	entry, exist := p.entries[k]
+	changed := !exist || entry.Value != value
+
+	entry = snapshotEntry{
+		Engine:    engine,
+		Key:       key,
+		Field:     field,
+		Value:     value,
+		UpdatedAt: now,
+		written:   entry.written,
+	}
+
+	p.entries[k] = entry
+
+	// the timestamp of an unchanged value is refreshed in memory, but only
+	// written once it is half way to stale, so the file is not rewritten by
+	// every render
+	if changed || now.Sub(entry.written) >= p.refresh() {
+		p.dirty = true
+	}
+}
+
+func (p *SnapshotCache) refresh() time.Duration {
+	if p.maxStaleness > 0 {
+		return p.maxStaleness / 2
+	}
+	return SNAPSHOT_DEFAULT_REFRESH
+}
+
+func (p *SnapshotCache) Get(engine, key, field string) (value StaleValue, exist bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.load()
+
+	entry, exist := p.entries[snapshotKey(engine, key, field)]
+	if !exist {
+		return
+	}
+
+	if p.expired(entry) {
+		exist = false
+		return
+	}
+
+	value = StaleValue{Value: entry.Value, UpdatedAt: entry.UpdatedAt}
+
+	return
+}
+
+// Flush writes the snapshot to disk if any value was put since the last flush,
+// the values beyond the max staleness are dropped
+func (p *SnapshotCache) Flush() (err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if !p.dirty {
+		return
+	}
+
+	var keys []string
+	var entries []snapshotEntry
+	for key, entry := range p.entries {
+		if p.expired(entry) {
+			delete(p.entries, key)
+			continue
+		}
+		keys = append(keys, key)
+		entries = append(entries, entry)
+	}
+
+	var data []byte
+	if data, err = json.MarshalIndent(entries, "", "    "); err != nil {
+		return
+	}
+
+	if err = writeFileAtomic(p.path, data, 0600); err != nil {
+		return
+	}
+
+	now := time.Now()
+	for i, key := range keys {
+		entries[i].written = now
+		p.entries[key] = entries[i]
+	}
+
+	p.dirty = false
+
+	return
+}
+
+func (p *SnapshotCache) expired(entry snapshotEntry) bool {
+	return p.maxStaleness > 0 && time.Since(entry.UpdatedAt) > p.maxStaleness
+}
+
+func (p *SnapshotCache) load() {
+	if p.loaded {
+		return
+	}
+
+	p.loaded = true
+
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return
+	}
+
+	var entries []snapshotEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if p.expired(entry) {
+			p.dirty = true
+			continue
+		}
+
+		entry.written = entry.UpdatedAt
+
+		key := snapshotKey(entry.Engine, entry.Key, entry.Field)
+		if _, exist := p.entries[key]; !exist {
+			p.entries[key] = entry
+		}
+	}
+}
+
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) (err error) {
+	return writeFileAtomicAs(fileName, data, perm, nil)
+}
+
+// writeFileAtomicAs writes the file by a temp file and rename, the file is
+// owned by the owner of the file info if it is not nil
+func writeFileAtomicAs(fileName string, data []byte, perm os.FileMode, owner os.FileInfo) (err error) {
+	var tmpName string
+	if tmpName, err = stageFile(fileName, data, perm, owner); err != nil {
+		return
+	}
+
+	if err = os.Rename(tmpName, fileName); err != nil {
+		os.Remove(tmpName)
+	}
+
+	return
+}
+
+// stageFile writes the data to a temp file beside the file, to be renamed to
+// the file
+func stageFile(fileName string, data []byte, perm os.FileMode, owner os.FileInfo) (tmpName string, err error) {
+	var tmp *os.File
+	if tmp, err = ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+"."); err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return
+	}
+
+	if owner != nil {
+		if err = chownAs(tmp.Name(), owner); err != nil {
+			return
+		}
+	}
+
+	tmpName = tmp.Name()
+
+	return
+}
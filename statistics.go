@@ -0,0 +1,184 @@
+package env_strings
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_STATISTICS_SIZE = 1000
+)
+
+const (
+	CACHE_HIT  = "hit"
+	CACHE_MISS = "miss"
+)
+
+type FuncStaticItem struct {
+	EnvName  string
+	FuncName string
+	Input    []interface{}
+	Output   []interface{}
+	Stale    bool
+	Cache    string
+	Time     time.Time
+	Duration time.Duration
+}
+
+func (p FuncStaticItem) MarshalJSON() ([]byte, error) {
+	output := make([]interface{}, len(p.Output))
+	for i, v := range p.Output {
+		if err, ok := v.(error); ok {
+			output[i] = err.Error()
+		} else {
+			output[i] = v
+		}
+	}
+
+	return json.Marshal(struct {
+		EnvName  string        `json:"env_name"`
+		FuncName string        `json:"func_name"`
+		Input    []interface{} `json:"input"`
+		Output   []interface{} `json:"output"`
+		Stale    bool          `json:"stale,omitempty"`
+		Cache    string        `json:"cache,omitempty"`
+		Time     time.Time     `json:"time"`
+		Duration time.Duration `json:"duration"`
+	}{p.EnvName, p.FuncName, p.Input, output, p.Stale, p.Cache, p.Time, p.Duration})
+}
+
+type FuncCounter struct {
+	Calls        int64         `json:"calls"`
+	Errors       int64         `json:"errors"`
+	TotalLatency time.Duration `json:"total_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+	CacheHits    int64         `json:"cache_hits"`
+	CacheMisses  int64         `json:"cache_misses"`
+}
+
+type FuncStatisticsSnapshot struct {
+	Items    []FuncStaticItem       `json:"items"`
+	Counters map[string]FuncCounter `json:"counters"`
+}
+
+// FuncStatistics keeps the recent calls in a ring buffer of fixed size, and the
+// counters of all the calls by func name
+type FuncStatistics struct {
+	locker   sync.Mutex
+	items    []FuncStaticItem
+	next     int
+	full     bool
+	counters map[string]*FuncCounter
+}
+
+func NewFuncStatistics(size int) *FuncStatistics {
+	if size <= 0 {
+		size = DEFAULT_STATISTICS_SIZE
+	}
+
+	return &FuncStatistics{
+		items:    make([]FuncStaticItem, size),
+		counters: make(map[string]*FuncCounter),
+	}
+}
+
+func (p *FuncStatistics) Add(item FuncStaticItem) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.items[p.next] = item
+	p.next = (p.next + 1) % len(p.items)
+	if p.next == 0 {
+		p.full = true
+	}
+
+	counter, exist := p.counters[item.FuncName]
+	if !exist {
+		counter = &FuncCounter{}
+		p.counters[item.FuncName] = counter
+	}
+
+	counter.Calls++
+	if len(item.Output) > 1 && item.Output[1] != nil {
+		counter.Errors++
+	}
+	switch item.Cache {
+	case CACHE_HIT:
+		{
+			counter.CacheHits++
+		}
+	case CACHE_MISS:
+		{
+			counter.CacheMisses++
+		}
+	}
+	counter.TotalLatency += item.Duration
+	if item.Duration > counter.MaxLatency {
+		counter.MaxLatency = item.Duration
+	}
+}
+
+// Items returns a copy of the recent calls, the oldest first
+func (p *FuncStatistics) Items() []FuncStaticItem {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.copyItems()
+}
+
+// Counters returns a copy of the counters by func name
+func (p *FuncStatistics) Counters() map[string]FuncCounter {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return p.copyCounters()
+}
+
+func (p *FuncStatistics) Snapshot() FuncStatisticsSnapshot {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return FuncStatisticsSnapshot{
+		Items:    p.copyItems(),
+		Counters: p.copyCounters(),
+	}
+}
+
+func (p *FuncStatistics) Reset() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.items = make([]FuncStaticItem, len(p.items))
+	p.next = 0
+	p.full = false
+	p.counters = make(map[string]*FuncCounter)
+}
+
+func (p *FuncStatistics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Snapshot())
+}
+
+func (p *FuncStatistics) copyItems() []FuncStaticItem {
+	var items []FuncStaticItem
+
+	if p.full {
+		items = append(items, p.items[p.next:]...)
+	}
+	items = append(items, p.items[:p.next]...)
+
+	for i := range items {
+		items[i].Input = append([]interface{}(nil), items[i].Input...)
+		items[i].Output = append([]interface{}(nil), items[i].Output...)
+	}
+
+	return items
+}
+
+func (p *FuncStatistics) copyCounters() map[string]FuncCounter {
+	counters := make(map[string]FuncCounter, len(p.counters))
+	for name, counter := range p.counters {
+		counters[name] = *counter
+	}
+	return counters
+}
@@ -1,13 +1,13 @@
 package env_strings
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
@@ -19,31 +19,73 @@ import (
 )
 
 var (
-	errorType = reflect.TypeOf((*error)(nil)).Elem()
-
-	funcStatics  = make(map[string][]FuncStaticItem)
-	staticLocker sync.Mutex
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 )
 
-type FuncStaticItem struct {
-	EnvName  string
-	FuncName string
-	Input    []interface{}
-	Output   []interface{}
-}
-
 type TemplateFuncs struct {
-	funcMap template.FuncMap
+	funcMap   template.FuncMap
+	external  map[string]bool
+	versioned map[string]bool
+	lockfile  *Lockfile
+	stats     *FuncStatistics
+	redactor  *Redactor
+	logger    *slog.Logger
+
+	cache       *funcCache
+	cacheTTLs   map[string]time.Duration
+	cacheLocker sync.Mutex
+
+	policies     map[string]*CallPolicy
+	policyLocker sync.Mutex
 }
 
 func NewTemplateFuncs() *TemplateFuncs {
-	return &TemplateFuncs{
-		funcMap: basicFuncs(),
+	tmplFuncs := &TemplateFuncs{
+		funcMap:   basicFuncs(),
+		external:  externalFuncs(),
+		versioned: make(map[string]bool),
+		stats:     NewFuncStatistics(DEFAULT_STATISTICS_SIZE),
+		redactor:  NewRedactor(RedactConfig{}),
+		logger:    newLogger(nil),
+		cache:     newFuncCache(CACHE_DEFAULT_MAX_ENTRIES),
+	}
+
+	tmplFuncs.replace(NewExtFuncsHTTP(HTTPConfig{}).GetFuncs())
+
+	return tmplFuncs
+}
+
+// render holds the state of one execution, the template is parsed, the tree is
+// loaded and the storage values are prefetched by the execution if they are nil. The memo keeps the
+// results of the external funcs of the execution, or of the executions of a
+// batch
+type render struct {
+	ctx        context.Context
+	envName    string
+	name       string
+	tracer     *renderTracer
+	tpl        *template.Template
+	tree       *envTree
+	prefetched template.FuncMap
+	memo       *funcCache
+}
+
+func newRender(ctx context.Context, envName string) *render {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return &render{
+		ctx:     withRenderScope(ctx),
+		envName: envName,
+		name:    "tmpl:" + envName,
+		memo:    newFuncCache(0),
 	}
 }
 
 func (p *TemplateFuncs) GetFuncMaps(envName string) template.FuncMap {
-	return p.hookedFuncs(envName)
+	return p.hookedFuncs(newRender(context.Background(), envName))
 }
 
 func (p *TemplateFuncs) Register(name string, function interface{}) (err error) {
@@ -67,6 +109,67 @@ func (p *TemplateFuncs) Register(name string, function interface{}) (err error)
 	return
 }
 
+// MarkExternal marks the funcs as external lookups, the results of which are
+// recorded to and replayed from the lockfile
+func (p *TemplateFuncs) MarkExternal(names ...string) {
+	for _, name := range names {
+		p.external[name] = true
+	}
+}
+
+// MarkVersioned marks the funcs as reading a generation of their storage, the
+// results of which are not cached across the renders
+func (p *TemplateFuncs) MarkVersioned(names ...string) {
+	for _, name := range names {
+		p.versioned[name] = true
+	}
+}
+
+// replace sets the funcs whether the names exist or not
+func (p *TemplateFuncs) replace(funcs template.FuncMap) {
+	for name, fn := range funcs {
+		p.funcMap[name] = fn
+	}
+}
+
+func (p *TemplateFuncs) Statistics() *FuncStatistics {
+	return p.stats
+}
+
+func (p *TemplateFuncs) SetStatistics(stats *FuncStatistics) {
+	p.stats = stats
+}
+
+func (p *TemplateFuncs) Redactor() *Redactor {
+	return p.redactor
+}
+
+func (p *TemplateFuncs) SetRedactor(redactor *Redactor) {
+	p.redactor = redactor
+}
+
+func (p *TemplateFuncs) SetLogger(logger *slog.Logger) {
+	p.logger = logger
+}
+
+func (p *TemplateFuncs) SetLockfile(lockfile *Lockfile) {
+	p.lockfile = lockfile
+}
+
+func externalFuncs() map[string]bool {
+	return map[string]bool{
+		"pwd":       true,
+		"getenv":    true,
+		"localtime": true,
+		"utc":       true,
+		"pid":       true,
+		"httpGet":   true,
+		"http_get":  true,
+		"http_json": true,
+		"envIfElse": true,
+	}
+}
+
 func basicFuncs() template.FuncMap {
 	m := make(template.FuncMap)
 	m["base"] = path.Base
@@ -82,7 +185,6 @@ func basicFuncs() template.FuncMap {
 	m["localtime"] = time.Now
 	m["utc"] = time.Now().UTC
 	m["pid"] = os.Getpid
-	m["httpGet"] = httpGet
 	m["envIfElse"] = envIfElse
 	m["md5"] = fmd5
 	m["base64"] = fbase64
@@ -96,71 +198,243 @@ func (p *TemplateFuncs) hookFunc(envName string, fn interface{}) interface{} {
 	}
 }
 
-func (p *TemplateFuncs) hookedFuncs(envName string) template.FuncMap {
+func (p *TemplateFuncs) hookedFuncs(r *render) template.FuncMap {
+	return p.hookFuncs(r, p.funcMap)
+}
+
+func (p *TemplateFuncs) hookFuncs(r *render, funcMap template.FuncMap) template.FuncMap {
 	hookedFuncs := template.FuncMap{}
 
-	for fName, originalFunc := range p.funcMap {
-		hookedFuncs[fName] = func(eName, funcName string, fn interface{}) interface{} {
+	for fName, originalFunc := range funcMap {
+		hookedFuncs[fName] = func(funcName string, fn interface{}) interface{} {
 			return func(args ...interface{}) (ret interface{}, err error) {
-				ret, err = call(fn, args...)
+				return p.invoke(r, funcName, fn, args)
+			}
+		}(fName, originalFunc)
+	}
 
-				if ret == nil && err == nil {
-					err = errors.New(fmt.Sprintf("the func of %s in env %s get <no value>", funcName, eName))
-				}
+	return hookedFuncs
+}
 
-				staticLocker.Lock()
-				defer staticLocker.Unlock()
+func (p *TemplateFuncs) invoke(r *render, funcName string, fn interface{}, args []interface{}) (ret interface{}, err error) {
+	envName := r.envName
 
-				staticItem := FuncStaticItem{
-					EnvName:  eName,
-					FuncName: funcName,
-					Input:    args,
-					Output:   []interface{}{ret, err},
-				}
+	if err = r.ctx.Err(); err != nil {
+		return
+	}
 
-				if items, exist := funcStatics[envName]; exist {
-					items = append(items, staticItem)
-					funcStatics[envName] = items
-				} else {
-					funcStatics[envName] = []FuncStaticItem{staticItem}
-				}
+	lockfile := p.lockfile
+	if !p.external[funcName] {
+		lockfile = nil
+	}
+
+	isStale := false
+	cache := ""
+	start := time.Now()
+
+	if lockfile != nil && lockfile.Mode() == LOCKFILE_REPLAY {
+		ret, err = lockfile.Replay(funcName, args)
+	} else {
+		ret, err, cache = p.cachedCall(r, funcName, fn, args)
+
+		var stale StaleValue
+		if stale, isStale = ret.(StaleValue); isStale {
+			ret = stale.Value
+
+			p.logger.Warn("stale value", "env", envName, "func", funcName, "updated_at", stale.UpdatedAt)
+		}
 
-				return
+		if lockfile != nil {
+			if recorded, e := lockfile.Record(funcName, args, ret, err); e != nil {
+				if err == nil {
+					err = fmt.Errorf("record func %s to lockfile failure: %s", funcName, e.Error())
+				}
+			} else if err == nil {
+				ret = recorded
 			}
-		}(envName, fName, originalFunc)
+		}
 	}
 
-	return hookedFuncs
-}
+	if ret == nil && err == nil {
+		err = errors.New(fmt.Sprintf("the func of %s in env %s get <no value>", funcName, envName))
+	}
 
-func UnmarshalJsonObject(data string) (map[string]interface{}, error) {
-	var ret map[string]interface{}
-	err := json.Unmarshal([]byte(data), &ret)
-	return ret, err
-}
+	if err != nil {
+		err = &FuncError{Func: funcName, EnvName: envName, Args: p.redactor.Item(FuncStaticItem{Input: args}).Input, Err: err}
+	}
 
-func UnmarshalJsonArray(data string) ([]interface{}, error) {
-	var ret []interface{}
-	err := json.Unmarshal([]byte(data), &ret)
-	return ret, err
+	if err == nil && p.redactor.IsSecretCall(funcName, args) {
+		p.redactor.AddSecret(ret)
+	}
+
+	item := p.redactor.Item(FuncStaticItem{
+		EnvName:  envName,
+		FuncName: funcName,
+		Input:    args,
+		Output:   []interface{}{ret, err},
+		Stale:    isStale,
+		Cache:    cache,
+		Time:     start,
+		Duration: time.Since(start),
+	})
+
+	p.stats.Add(item)
+
+	if r.tracer != nil {
+		r.tracer.call(funcName, item.Input)
+	}
+
+	p.logger.Debug("func called", "env", envName, "func", funcName, "args", item.Input, "duration", item.Duration, "error", item.Output[1])
+
+	return
 }
 
-func httpGet(url string) (ret string, err error) {
-	var resp *http.Response
-	if resp, err = http.Get(url); err != nil {
+// cachedCall calls the external func through the memo of the render and the
+// ttl cache of the func, the failed calls and the stale values are not kept
+// across the renders
+func (p *TemplateFuncs) cachedCall(r *render, funcName string, fn interface{}, args []interface{}) (ret interface{}, err error, cache string) {
+	key, ok := callKey(funcName, args)
+	if !p.external[funcName] || r.memo == nil || !ok {
+		ret, err = p.call(r.ctx, funcName, fn, args)
 		return
 	}
 
-	var body []byte
-	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+	if ret, ok = r.memo.get(key); ok {
+		cache = CACHE_HIT
+		return
+	}
+
+	ttl := p.cacheTTLOf(funcName)
+
+	if ttl > 0 {
+		if ret, ok = p.cache.get(key); ok {
+			r.memo.put(key, ret, 0)
+			cache = CACHE_HIT
+			return
+		}
+	}
+
+	cache = CACHE_MISS
+
+	if ret, err = p.call(r.ctx, funcName, fn, args); err != nil {
 		return
 	}
 
-	ret = string(body)
+	r.memo.put(key, ret, 0)
+
+	if _, stale := ret.(StaleValue); ttl > 0 && !stale {
+		p.cache.put(key, ret, ttl)
+	}
 
 	return
 }
 
+// cached reports whether the result of the call is cached across the renders,
+// so it needs not be prefetched
+func (p *TemplateFuncs) cached(call FuncCall) bool {
+	if !p.external[call.Name] || p.cacheTTLOf(call.Name) <= 0 {
+		return false
+	}
+
+	args := make([]interface{}, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = arg
+	}
+
+	key, ok := callKey(call.Name, args)
+	if !ok {
+		return false
+	}
+
+	_, ok = p.cache.get(key)
+
+	return ok
+}
+
+// cacheTTLOf returns the ttl of the func, zero for the versioned funcs, whose
+// values cached from other generations must not mix with the render's
+func (p *TemplateFuncs) cacheTTLOf(funcName string) time.Duration {
+	if p.versioned[funcName] {
+		return 0
+	}
+
+	ttl, _ := p.CacheTTL(funcName)
+
+	return ttl
+}
+
+func (p *TemplateFuncs) SetCacheMaxEntries(maxEntries int) {
+	p.cache.locker.Lock()
+	defer p.cache.locker.Unlock()
+
+	p.cache.maxEntries = maxEntries
+}
+
+// SetCacheTTL caches the results of the external func across the renders for
+// the ttl, a zero ttl disables the cache
+func (p *TemplateFuncs) SetCacheTTL(funcName string, ttl time.Duration) {
+	p.cacheLocker.Lock()
+	defer p.cacheLocker.Unlock()
+
+	if p.cacheTTLs == nil {
+		p.cacheTTLs = make(map[string]time.Duration)
+	}
+	p.cacheTTLs[funcName] = ttl
+}
+
+func (p *TemplateFuncs) CacheTTL(funcName string) (ttl time.Duration, exist bool) {
+	p.cacheLocker.Lock()
+	defer p.cacheLocker.Unlock()
+
+	ttl, exist = p.cacheTTLs[funcName]
+	return
+}
+
+// call calls the func by its policy, if there is one
+func (p *TemplateFuncs) call(ctx context.Context, funcName string, fn interface{}, args []interface{}) (interface{}, error) {
+	if policy := p.Policy(funcName); policy != nil {
+		return policy.call(ctx, fn, args)
+	}
+	return callContext(ctx, fn, args...)
+}
+
+// SetPolicy applies the timeout, retries and breaker of the policy to the calls
+// of the func, a nil policy removes it
+func (p *TemplateFuncs) SetPolicy(funcName string, policy *CallPolicy) {
+	p.policyLocker.Lock()
+	defer p.policyLocker.Unlock()
+
+	if p.policies == nil {
+		p.policies = make(map[string]*CallPolicy)
+	}
+
+	if policy == nil {
+		delete(p.policies, funcName)
+		return
+	}
+
+	p.policies[funcName] = policy
+}
+
+func (p *TemplateFuncs) Policy(funcName string) *CallPolicy {
+	p.policyLocker.Lock()
+	defer p.policyLocker.Unlock()
+
+	return p.policies[funcName]
+}
+
+func UnmarshalJsonObject(data string) (map[string]interface{}, error) {
+	var ret map[string]interface{}
+	err := json.Unmarshal([]byte(data), &ret)
+	return ret, err
+}
+
+func UnmarshalJsonArray(data string) ([]interface{}, error) {
+	var ret []interface{}
+	err := json.Unmarshal([]byte(data), &ret)
+	return ret, err
+}
+
 func envIfElse(envName, equalValue, trueValue, falseValue string) string {
 	if os.Getenv(envName) == equalValue {
 		return trueValue
@@ -190,6 +464,20 @@ func fmd5(str string) string {
 	return fmt.Sprintf("%0x", v[:])
 }
 
+// takesContext reports whether the first param of the function is a
+// context.Context, which will be given the context of the execution
+func takesContext(fn interface{}) bool {
+	typ := reflect.TypeOf(fn)
+	return typ != nil && typ.Kind() == reflect.Func && typ.NumIn() > 0 && typ.In(0) == contextType
+}
+
+func callContext(ctx context.Context, fn interface{}, args ...interface{}) (interface{}, error) {
+	if takesContext(fn) {
+		args = append([]interface{}{ctx}, args...)
+	}
+	return call(fn, args...)
+}
+
 // The following code copy from pkg "text/template"
 
 // call returns the result of evaluating the first argument as a function.
@@ -0,0 +1,94 @@
+package env_strings
+
+import (
+	"text/template"
+	"text/template/parse"
+)
+
+func walkNode(node parse.Node, fn func(node parse.Node)) {
+	if node == nil {
+		return
+	}
+
+	fn(node)
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		{
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walkNode(child, fn)
+			}
+		}
+	case *parse.ActionNode:
+		{
+			walkNode(n.Pipe, fn)
+		}
+	case *parse.PipeNode:
+		{
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walkNode(cmd, fn)
+			}
+		}
+	case *parse.CommandNode:
+		{
+			for _, arg := range n.Args {
+				walkNode(arg, fn)
+			}
+		}
+	case *parse.ChainNode:
+		{
+			walkNode(n.Node, fn)
+		}
+	case *parse.IfNode:
+		{
+			walkBranch(&n.BranchNode, fn)
+		}
+	case *parse.RangeNode:
+		{
+			walkBranch(&n.BranchNode, fn)
+		}
+	case *parse.WithNode:
+		{
+			walkBranch(&n.BranchNode, fn)
+		}
+	case *parse.TemplateNode:
+		{
+			walkNode(n.Pipe, fn)
+		}
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(node parse.Node)) {
+	walkNode(n.Pipe, fn)
+	if n.List != nil {
+		walkNode(n.List, fn)
+	}
+	if n.ElseList != nil {
+		walkNode(n.ElseList, fn)
+	}
+}
+
+// literalCalls returns the calls of the named funcs whose args are all string literals
+func literalCalls(tpl *template.Template, names template.FuncMap) (calls []FuncCall) {
+	for _, call := range literalCallRefs(tpl, names) {
+		calls = append(calls, FuncCall{Name: call.Name, Args: call.Args})
+	}
+	return
+}
+
+// literalCallRefs returns the calls of the named funcs found by the analysis
+// of the template whose args are all string literals
+func literalCallRefs(tpl *template.Template, names template.FuncMap) (calls []FuncReference) {
+	for _, call := range analyzeTemplate(tpl).Calls {
+		if _, exist := names[call.Name]; exist {
+			calls = append(calls, call)
+		}
+	}
+	return
+}
@@ -0,0 +1,224 @@
+package env_strings
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	traceBeginFunc = "__env_strings_span_begin"
+	traceEndFunc   = "__env_strings_span_end"
+)
+
+type SpanCall struct {
+	Func string        `json:"func"`
+	Args []interface{} `json:"args"`
+}
+
+// RenderSpan is the output of one action of the template, from Start to End
+// in bytes of the rendered string
+type RenderSpan struct {
+	Start    int        `json:"start"`
+	End      int        `json:"end"`
+	Node     string     `json:"node"`
+	Position string     `json:"position"`
+	Fields   []string   `json:"fields,omitempty"`
+	Calls    []SpanCall `json:"calls,omitempty"`
+}
+
+// ExecuteTraced executes the template as ExecuteWith, and returns the spans of
+// the output with the action, the fields and the func calls producing them
+func (p *EnvStrings) ExecuteTraced(str string, envValues map[string]interface{}) (ret string, spans []RenderSpan, err error) {
+	r := newRender(context.Background(), p.envName)
+	r.tracer = newRenderTracer()
+
+	var buf bytes.Buffer
+	if err = p.execute(r, str, envValues, &buf); err != nil {
+		return
+	}
+
+	ret = buf.String()
+	spans = r.tracer.spans
+
+	return
+}
+
+type traceAction struct {
+	node     string
+	position string
+	fields   []string
+}
+
+// renderTracer marks every printing action of the template by a call before
+// it and a command at the end of its pipeline, the next write after the end
+// command is the output of the action
+type renderTracer struct {
+	writer  io.Writer
+	actions []traceAction
+	offset  int
+	current int
+	pending int
+	calls   []SpanCall
+	spans   []RenderSpan
+}
+
+func newRenderTracer() *renderTracer {
+	return &renderTracer{current: -1, pending: -1}
+}
+
+func (p *renderTracer) instrument(tpl *template.Template, w io.Writer) io.Writer {
+	p.writer = w
+
+	tpl.Funcs(template.FuncMap{
+		traceBeginFunc: p.begin,
+		traceEndFunc:   p.end,
+	})
+
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			p.instrumentList(t, t.Tree.Root)
+		}
+	}
+
+	return p
+}
+
+func (p *renderTracer) instrumentList(tpl *template.Template, list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+
+	var nodes []parse.Node
+
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			{
+				if n.Pipe == nil || len(n.Pipe.Decl) > 0 {
+					break
+				}
+
+				location, _ := tpl.ErrorContext(n)
+
+				id := len(p.actions)
+				p.actions = append(p.actions, traceAction{
+					node:     n.String(),
+					position: location,
+					fields:   pipeFields(n.Pipe),
+				})
+
+				nodes = append(nodes, traceBeginNode(tpl.Tree, n, id))
+
+				n.Pipe.Cmds = append(n.Pipe.Cmds, traceCommand(tpl.Tree, n.Pos, traceEndFunc, id))
+			}
+		case *parse.IfNode:
+			{
+				p.instrumentList(tpl, n.List)
+				p.instrumentList(tpl, n.ElseList)
+			}
+		case *parse.RangeNode:
+			{
+				p.instrumentList(tpl, n.List)
+				p.instrumentList(tpl, n.ElseList)
+			}
+		case *parse.WithNode:
+			{
+				p.instrumentList(tpl, n.List)
+				p.instrumentList(tpl, n.ElseList)
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	list.Nodes = nodes
+}
+
+func (p *renderTracer) begin(id int) string {
+	p.current = id
+	p.calls = nil
+	return ""
+}
+
+func (p *renderTracer) end(id int, v interface{}) interface{} {
+	p.pending = id
+	return v
+}
+
+func (p *renderTracer) call(funcName string, args []interface{}) {
+	if p.current >= 0 {
+		p.calls = append(p.calls, SpanCall{Func: funcName, Args: args})
+	}
+}
+
+func (p *renderTracer) Write(b []byte) (n int, err error) {
+	if p.pending >= 0 {
+		action := p.actions[p.pending]
+
+		p.spans = append(p.spans, RenderSpan{
+			Start:    p.offset,
+			End:      p.offset + len(b),
+			Node:     action.node,
+			Position: action.position,
+			Fields:   action.fields,
+			Calls:    p.calls,
+		})
+
+		p.pending = -1
+		p.current = -1
+		p.calls = nil
+	}
+
+	n, err = p.writer.Write(b)
+	p.offset += n
+
+	return
+}
+
+// pipeFields returns the fields and variables referenced by the pipeline
+func pipeFields(pipe *parse.PipeNode) (fields []string) {
+	walkNode(pipe, func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.FieldNode, *parse.VariableNode, *parse.ChainNode, *parse.DotNode:
+			{
+				fields = append(fields, n.String())
+			}
+		}
+	})
+	return
+}
+
+func traceBeginNode(tree *parse.Tree, action *parse.ActionNode, id int) *parse.ActionNode {
+	return &parse.ActionNode{
+		NodeType: parse.NodeAction,
+		Pos:      action.Pos,
+		Line:     action.Line,
+		Pipe: &parse.PipeNode{
+			NodeType: parse.NodePipe,
+			Pos:      action.Pos,
+			Line:     action.Line,
+			Cmds:     []*parse.CommandNode{traceCommand(tree, action.Pos, traceBeginFunc, id)},
+		},
+	}
+}
+
+func traceCommand(tree *parse.Tree, pos parse.Pos, funcName string, id int) *parse.CommandNode {
+	return &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      pos,
+		Args: []parse.Node{
+			parse.NewIdentifier(funcName).SetTree(tree).SetPos(pos),
+			&parse.NumberNode{
+				NodeType: parse.NodeNumber,
+				Pos:      pos,
+				IsInt:    true,
+				Int64:    int64(id),
+				Text:     strconv.Itoa(id),
+			},
+		},
+	}
+}
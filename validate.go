@@ -0,0 +1,189 @@
+package env_strings
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+type ValidationIssue struct {
+	Position string
+	Node     string
+	Err      error
+}
+
+func (p ValidationIssue) Error() string {
+	return fmt.Sprintf("%s: %s: %s", p.Position, p.Node, p.Err.Error())
+}
+
+func (p ValidationIssue) Unwrap() error {
+	return p.Err
+}
+
+// ValidationError lists every missing key and failing storage lookup of the
+// template
+type ValidationError struct {
+	Issues []ValidationIssue
+}
+
+func (p *ValidationError) Error() string {
+	lines := make([]string, 0, len(p.Issues)+1)
+	lines = append(lines, fmt.Sprintf("template has %d issue(s):", len(p.Issues)))
+	for _, issue := range p.Issues {
+		lines = append(lines, "  "+issue.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *ValidationError) Unwrap() []error {
+	errs := make([]error, len(p.Issues))
+	for i, issue := range p.Issues {
+		errs[i] = issue
+	}
+	return errs
+}
+
+// Validate checks the template against the env tree without rendering it, the
+// fields are resolved statically and the storage lookups with literal args are
+// only read, every missing key and failing lookup is returned in one
+// *ValidationError. The other funcs, such as http_get or the registered ones,
+// are not called, so their failures only show up by rendering
+func (p *EnvStrings) Validate(str string, envValues map[string]interface{}) (err error) {
+	return p.ValidateContext(context.Background(), str, envValues)
+}
+
+func (p *EnvStrings) ValidateContext(ctx context.Context, str string, envValues map[string]interface{}) (err error) {
+	redactor := p.tmplFuncs.Redactor()
+
+	defer func() {
+		err = redactor.Error(err)
+	}()
+
+	var tree *envTree
+	if tree, err = p.loadTree(envValues); err != nil {
+		return
+	}
+
+	redactor.TreeSecrets(tree.values)
+
+	var tpl *template.Template
+	if tpl, err = template.New("tmpl:" + p.envName).Funcs(p.tmplFuncs.GetFuncMaps(p.envName)).Option("missingkey=error").Parse(str); err != nil {
+		return
+	}
+
+	analysis := analyzeTemplate(tpl)
+
+	v := &validator{tpl: tpl, values: tree.values}
+
+	for _, field := range analysis.Fields {
+		v.field(field)
+	}
+
+	p.validateCalls(ctx, v, analysis)
+
+	if len(v.issues) > 0 {
+		err = &ValidationError{Issues: v.issues}
+	}
+
+	return
+}
+
+// validateCalls reads the storage lookups with literal args of the analysis
+// through the prefetcher of each storage, the calls with a default value never
+// fail
+func (p *EnvStrings) validateCalls(ctx context.Context, v *validator, analysis *Analysis) {
+	redactor := p.tmplFuncs.Redactor()
+
+	for _, extFuncs := range p.extFuncs {
+		prefetcher, ok := extFuncs.(ExtFuncsPrefetcher)
+		if !ok {
+			continue
+		}
+
+		storageFuncs := prefetcher.GetFuncs()
+
+		var calls []FuncReference
+		for _, call := range analysis.Calls {
+			if _, exist := storageFuncs[call.Name]; exist {
+				calls = append(calls, call)
+			}
+		}
+
+		if len(calls) == 0 {
+			continue
+		}
+
+		funcCalls := make([]FuncCall, len(calls))
+		for i, call := range calls {
+			funcCalls[i] = FuncCall{Name: call.Name, Args: call.Args}
+		}
+
+		funcs, err := p.prefetchStorage(ctx, prefetcher, funcCalls)
+		if funcs == nil {
+			funcs = make(template.FuncMap)
+		}
+
+		for _, call := range calls {
+			callErr := err
+
+			args := make([]interface{}, len(call.Args))
+			for i, arg := range call.Args {
+				args[i] = arg
+			}
+
+			if callErr == nil {
+				fn, exist := funcs[call.Name]
+				if !exist {
+					fn = storageFuncs[call.Name]
+				}
+
+				var ret interface{}
+				if ret, callErr = callContext(ctx, fn, args...); callErr == nil && ret == nil {
+					callErr = fmt.Errorf("the func of %s in env %s get <no value>", call.Name, p.envName)
+				}
+			}
+
+			if callErr != nil {
+				v.issue(call.node, &FuncError{Func: call.Name, EnvName: p.envName, Args: redactor.Item(FuncStaticItem{Input: args}).Input, Position: call.Position, Err: callErr})
+			}
+		}
+	}
+}
+
+type validator struct {
+	tpl    *template.Template
+	values map[string]interface{}
+	issues []ValidationIssue
+}
+
+func (p *validator) issue(node parse.Node, err error) {
+	location, _ := p.tpl.ErrorContext(node)
+	p.issues = append(p.issues, ValidationIssue{
+		Position: location,
+		Node:     node.String(),
+		Err:      err,
+	})
+}
+
+// field checks the keys of the field exist in the env tree, the keys of the
+// dot or the variable were checked where they were set
+func (p *validator) field(field FieldReference) {
+	var v interface{} = p.values
+
+	for i, key := range field.keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			// the value is not a map, the field could not be resolved statically
+			return
+		}
+
+		if v, ok = m[key]; !ok {
+			if i >= field.base {
+				p.issue(field.node, &MissingKeyError{Path: field.node.String(), Key: key, Position: field.Position})
+			}
+			return
+		}
+	}
+}